@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/rethinkdb/prometheus-exporter/config"
+	"github.com/rethinkdb/prometheus-exporter/dbconnector"
+	"github.com/rethinkdb/prometheus-exporter/exporter"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// generation is the exporter with its sessions and background workers built from one config.
+// Config reload builds a new generation and swaps it with the current one.
+type generation struct {
+	cfg           config.Config
+	rconn         *dbconnector.LazyRethinkSession
+	clientCert    *dbconnector.CertificateReloader
+	probeSessions *dbconnector.ProbeSessions
+	exp           *exporter.RethinkdbExporter
+
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+}
+
+// newGeneration validates the config and builds the exporter.
+// Sessions of the previous generation are reused if their connection parameters are not changed.
+func newGeneration(cmd *cobra.Command, cfg config.Config, prev *generation) (*generation, error) {
+	if cfg.DB.UsernameFile != "" || cfg.DB.PasswordFile != "" {
+		var err error
+		cfg.DB.Username, cfg.DB.Password, err = dbconnector.ReadCredentials(cfg.DB.Username, cfg.DB.Password, cfg.DB.UsernameFile, cfg.DB.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	g := &generation{cfg: cfg}
+
+	if prev != nil && reflect.DeepEqual(prev.cfg.DB, cfg.DB) {
+		g.rconn = prev.rconn
+		g.clientCert = prev.clientCert
+	} else {
+		if cfg.DB.EnableTLS {
+			var err error
+			g.clientCert, err = dbconnector.PrepareTLSConfig(dbconnector.TLSOptions{
+				CAFile:             cfg.DB.CAFile,
+				CertFile:           cfg.DB.CertificateFile,
+				KeyFile:            cfg.DB.KeyFile,
+				ServerName:         cfg.DB.TLSServerName,
+				InsecureSkipVerify: cfg.DB.TLSInsecureSkipVerify,
+				MinVersion:         cfg.DB.TLSMinVersion,
+				MaxVersion:         cfg.DB.TLSMaxVersion,
+				CipherSuites:       cfg.DB.TLSCipherSuites,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to read tls credentials: %v", err)
+			}
+		}
+		g.rconn = dbconnector.ConnectRethinkDB(
+			cfg.DB.RethinkdbAddresses,
+			cfg.DB.Username,
+			cfg.DB.Password,
+			g.clientCert,
+			cfg.DB.ConnectionPoolSize,
+		)
+	}
+
+	var probes exporter.ProbeConnector
+	if cfg.Web.ProbePath != "" {
+		if prev != nil && prev.probeSessions != nil &&
+			reflect.DeepEqual(prev.cfg.DB, cfg.DB) && reflect.DeepEqual(prev.cfg.Modules, cfg.Modules) {
+			g.probeSessions = prev.probeSessions
+		} else {
+			modules, err := prepareProbeModules(cfg)
+			if err != nil {
+				return nil, fmt.Errorf("failed to prepare probe modules: %v", err)
+			}
+			g.probeSessions = dbconnector.NewProbeSessions(modules)
+		}
+		probes = g.probeSessions
+	}
+
+	dbFilter, err := exporter.NewNameFilter(cfg.Stats.Filters.DB.Include, cfg.Stats.Filters.DB.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse db filter: %v", err)
+	}
+	tableFilter, err := exporter.NewNameFilter(cfg.Stats.Filters.Table.Include, cfg.Stats.Filters.Table.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse table filter: %v", err)
+	}
+	serverFilter, err := exporter.NewNameFilter(cfg.Stats.Filters.Server.Include, cfg.Stats.Filters.Server.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse server filter: %v", err)
+	}
+
+	collectors := make(map[string]bool, len(cfg.Collectors))
+	for name, enabled := range cfg.Collectors {
+		collectors[name] = enabled
+	}
+	for name := range exporter.Collectors() {
+		disabled, _ := cmd.Flags().GetBool("no-collector." + name)
+		if disabled {
+			collectors[name] = false
+		}
+	}
+
+	// interface must stay nil without the certificate
+	var clientCert exporter.ClientCertificate
+	if g.clientCert != nil {
+		clientCert = g.clientCert
+	}
+
+	g.exp, err = exporter.New(
+		cfg.Web.TelemetryPath,
+		cfg.Web.ProbePath,
+		g.rconn,
+		probes,
+		exporter.Options{
+			TableDocsEstimates:       cfg.Stats.TableDocsEstimates,
+			TableInfoWorkers:         cfg.Stats.TableInfoWorkers,
+			TableInfoRefreshInterval: cfg.Stats.TableInfoRefreshInterval,
+			ScrapeTimeout:            cfg.Stats.ScrapeTimeout,
+			DBFilter:                 dbFilter,
+			TableFilter:              tableFilter,
+			ServerFilter:             serverFilter,
+			Namespace:                cfg.Metrics.Namespace,
+			LegacyNames:              cfg.Metrics.LegacyNames,
+			ConstLabels:              cfg.Metrics.ConstLabels,
+			Collectors:               collectors,
+			NodeAddresses:            cfg.Nodes.Addresses,
+			CustomQueries:            customQueries(cfg.CustomQueries),
+			ClientCertificate:        clientCert,
+			LatencyProbe: exporter.LatencyProbeOptions{
+				Enabled:     cfg.LatencyProbe.Enabled,
+				Interval:    cfg.LatencyProbe.Interval,
+				DB:          cfg.LatencyProbe.DB,
+				Table:       cfg.LatencyProbe.Table,
+				CreateTable: cfg.LatencyProbe.CreateTable,
+				Durability:  cfg.LatencyProbe.Durability,
+				ReadMode:    cfg.LatencyProbe.ReadMode,
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// start runs background collecting, the latency probe and watching credential files if they are enabled
+func (g *generation) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	g.cancel = cancel
+
+	if g.cfg.DB.UsernameFile != "" || g.cfg.DB.PasswordFile != "" {
+		// the default probe module uses the exporter credentials unless it is configured
+		var onChange func(username, password string)
+		if _, configured := g.cfg.Modules[exporter.DefaultProbeModule]; !configured && g.probeSessions != nil {
+			onChange = func(username, password string) {
+				g.probeSessions.SetCredentials(exporter.DefaultProbeModule, username, password)
+			}
+		}
+		g.workers.Add(1)
+		go func() {
+			defer g.workers.Done()
+			g.rconn.WatchCredentials(ctx, g.cfg.DB.UsernameFile, g.cfg.DB.PasswordFile, onChange)
+		}()
+	}
+
+	if g.cfg.Stats.BackgroundInterval > 0 {
+		log.Info().Dur("interval", g.cfg.Stats.BackgroundInterval).Msg("collecting stats in background")
+		g.workers.Add(1)
+		go func() {
+			defer g.workers.Done()
+			g.exp.CollectInBackground(ctx, g.cfg.Stats.BackgroundInterval)
+		}()
+	}
+
+	if g.cfg.LatencyProbe.Enabled {
+		log.Info().Dur("interval", g.cfg.LatencyProbe.Interval).Str("db", g.cfg.LatencyProbe.DB).Str("table", g.cfg.LatencyProbe.Table).Msg("running latency probe")
+		g.workers.Add(1)
+		go func() {
+			defer g.workers.Done()
+			g.exp.RunLatencyProbe(ctx)
+		}()
+	}
+}
+
+// stop cancels background workers and waits for them
+func (g *generation) stop() {
+	g.cancel()
+	g.workers.Wait()
+}
+
+// close closes sessions which are not reused by the next generation, next is nil on shutdown
+func (g *generation) close(next *generation) {
+	if g.probeSessions != nil && (next == nil || next.probeSessions != g.probeSessions) {
+		if err := g.probeSessions.Close(); err != nil {
+			log.Warn().Err(err).Msg("failed to close probe sessions")
+		}
+	}
+	if next == nil || next.rconn != g.rconn {
+		if err := g.rconn.Close(); err != nil {
+			log.Warn().Err(err).Msg("failed to close rethinkdb session")
+		}
+	}
+}
+
+// warnNotReloadable logs changed parameters which require restart
+func warnNotReloadable(cur, next config.Config) {
+	if cur.Web.ListenAddress != next.Web.ListenAddress ||
+		cur.Web.ConfigFile != next.Web.ConfigFile ||
+		cur.Web.ShutdownGracePeriod != next.Web.ShutdownGracePeriod ||
+		cur.Log != next.Log {
+		log.Warn().Msg("listen address, web config file, shutdown grace period and log parameters require restart")
+	}
+}
@@ -1,7 +1,13 @@
 package cmd
 
 import (
-	"crypto/tls"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/rethinkdb/prometheus-exporter/config"
 	"github.com/rethinkdb/prometheus-exporter/dbconnector"
@@ -22,36 +28,70 @@ var rootCmd = &cobra.Command{
 		initLogging(cfg)
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		var tlsConfig *tls.Config
-		var err error
-		if cfg.DB.EnableTLS {
-			tlsConfig, err = dbconnector.PrepareTLSConfig(cfg.DB.CAFile, cfg.DB.CertificateFile, cfg.DB.KeyFile)
-			if err != nil {
-				log.Fatal().Err(err).Msg("failed to read tls credentials")
-			}
+		current, err := newGeneration(cmd, cfg, nil)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to init http exporter")
 		}
+		current.start()
 
-		rconn := dbconnector.ConnectRethinkDB(
-			cfg.DB.RethinkdbAddresses,
-			cfg.DB.Username,
-			cfg.DB.Password,
-			tlsConfig,
-			cfg.DB.ConnectionPoolSize,
-		)
+		var server *exporter.Server
+		reload := func() error {
+			newCfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			warnNotReloadable(current.cfg, newCfg)
 
-		exp, err := exporter.New(cfg.Web.ListenAddress, cfg.Web.TelemetryPath, rconn, cfg.Stats.TableDocsEstimates)
-		if err != nil {
-			log.Fatal().Err(err).Msg("failed to init http exporter")
+			next, err := newGeneration(cmd, newCfg, current)
+			if err != nil {
+				return err
+			}
+			next.start()
+			err = server.Swap(next.exp)
+			if err != nil {
+				next.stop()
+				next.close(current)
+				return err
+			}
+			current.stop()
+			current.close(next)
+			current = next
+			return nil
 		}
+		server = exporter.NewServer(cfg.Web.ListenAddress, cfg.Web.ConfigFile, current.exp, reload)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go handleSignals(server, cancel)
 
 		log.Info().Str("address", cfg.Web.ListenAddress).Msg("listening on address")
-		err = exp.ListenAndServe()
+		err = server.ListenAndServe(ctx, cfg.Web.ShutdownGracePeriod)
 		if err != nil {
 			log.Fatal().Err(err).Msg("failed to serve http exporter")
 		}
+
+		// reloads are not running after the server is stopped
+		current.stop()
+		current.close(nil)
+		log.Info().Msg("exporter stopped")
 	},
 }
 
+// handleSignals reloads config on SIGHUP and cancels the exporter on SIGTERM or SIGINT
+func handleSignals(server *exporter.Server, cancel context.CancelFunc) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	for sig := range sigs {
+		if sig == syscall.SIGHUP {
+			log.Info().Msg("reloading config")
+			_ = server.Reload()
+			continue
+		}
+		log.Info().Str("signal", sig.String()).Msg("shutting down")
+		cancel()
+		return
+	}
+}
+
 // Execute runs root command of cli of the exporter
 func Execute() error {
 	return rootCmd.Execute()
@@ -62,31 +102,80 @@ func init() {
 	rootCmd.PersistentFlags().Bool("log.debug", false, "Verbose debug logs")
 	rootCmd.PersistentFlags().Bool("log.json-output", false, "Use JSON output for logs")
 
+	for name, enabled := range exporter.Collectors() {
+		rootCmd.PersistentFlags().Bool("collector."+name, enabled, fmt.Sprintf("Enable the %v collector", name))
+		rootCmd.PersistentFlags().Bool("no-collector."+name, false, fmt.Sprintf("Disable the %v collector", name))
+	}
+
+	rootCmd.PersistentFlags().StringSlice("nodes.address", nil, "Address of the node checked directly by nodes collector, discovered from server status if not set")
+
+	rootCmd.PersistentFlags().String("metrics.namespace", "rethinkdb", "Prefix of exported metrics names")
+	rootCmd.PersistentFlags().Bool("metrics.legacy-names", false, "Export metrics with old unprefixed names for existing dashboards")
+
 	rootCmd.PersistentFlags().StringSlice("db.address", []string{"localhost:28015"}, "Address of one or more nodes of rethinkdb")
 	rootCmd.PersistentFlags().String("db.username", "", "Username of rethinkdb user")
 	rootCmd.PersistentFlags().String("db.password", "", "Password of rethinkdb user")
+	rootCmd.PersistentFlags().String("db.username-file", "", "Path to file with username of rethinkdb user, watched for changes")
+	rootCmd.PersistentFlags().String("db.password-file", "", "Path to file with password of rethinkdb user, watched for changes")
 	rootCmd.PersistentFlags().Bool("db.enable-tls", false, "Enable to use tls connection")
 	rootCmd.PersistentFlags().String("db.ca", "", "Path to CA certificate file for tls connection")
 	rootCmd.PersistentFlags().String("db.cert", "", "Path to certificate file for tls connection")
 	rootCmd.PersistentFlags().String("db.key", "", "Path to key file for tls connection")
+	rootCmd.PersistentFlags().String("db.tls-server-name", "", "Server name verified in the rethinkdb certificate instead of the address host")
+	rootCmd.PersistentFlags().Bool("db.tls-insecure-skip-verify", false, "Skip verification of the rethinkdb certificate, only for testing")
+	rootCmd.PersistentFlags().String("db.tls-min-version", "", "Minimum TLS version of rethinkdb connection: TLS10, TLS11, TLS12 or TLS13")
+	rootCmd.PersistentFlags().String("db.tls-max-version", "", "Maximum TLS version of rethinkdb connection: TLS10, TLS11, TLS12 or TLS13")
+	rootCmd.PersistentFlags().StringSlice("db.tls-cipher-suites", nil, "Cipher suites of rethinkdb connection for TLS 1.2 and lower, Go defaults if not set")
 	rootCmd.PersistentFlags().Int("db.pool-size", 5, "Size of connection pool to rethinkdb")
 
 	rootCmd.PersistentFlags().String("web.listen-address", "0.0.0.0:9055", "Address to listen on for web interface and telemetry")
 	rootCmd.PersistentFlags().String("web.telemetry-path", "/metrics", "Path under which to expose metrics")
+	rootCmd.PersistentFlags().String("web.probe-path", "", "Path under which to probe targets, e.g. /probe, disabled if empty")
+	rootCmd.PersistentFlags().String("web.config-file", "", "Path to web config file with TLS and basic auth settings")
+	rootCmd.PersistentFlags().Duration("web.shutdown-grace-period", 10*time.Second, "Time to finish in-flight requests on shutdown before they are cancelled")
 
 	rootCmd.PersistentFlags().Bool("stats.table-estimates", false, "Collect docs count estimates for each table")
+	rootCmd.PersistentFlags().Int("stats.table-info-workers", 4, "Max number of concurrent queries of table docs count estimates")
+	rootCmd.PersistentFlags().Duration("stats.table-info-refresh-interval", 0, "Interval of refreshing table docs count estimates, cached estimates are exported between refreshes (0 to refresh on every scrape)")
+	rootCmd.PersistentFlags().Duration("stats.background-interval", 0, "Collect stats in background with the interval and serve the last result on scrapes (0 to collect on every scrape)")
+	rootCmd.PersistentFlags().Duration("stats.scrape-timeout", 10*time.Second, "Timeout of collecting stats, lowered to prometheus scrape timeout if it is less (0 to disable)")
+
+	rootCmd.PersistentFlags().Bool("latency-probe.enabled", false, "Probe read and write latency with a probe table")
+	rootCmd.PersistentFlags().Duration("latency-probe.interval", 15*time.Second, "Interval of latency probes")
+	rootCmd.PersistentFlags().String("latency-probe.db", "rethinkdb_exporter", "Database of the latency probe table")
+	rootCmd.PersistentFlags().String("latency-probe.table", "latency_probe", "Latency probe table")
+	rootCmd.PersistentFlags().Bool("latency-probe.create-table", true, "Create the latency probe database and table if they don't exist")
+	rootCmd.PersistentFlags().String("latency-probe.durability", "hard", "Durability of latency probe writes: hard or soft")
+	rootCmd.PersistentFlags().String("latency-probe.read-mode", "single", "Read mode of latency probe reads: single, majority or outdated")
 
 	_ = viper.BindPFlag("log.debug", rootCmd.PersistentFlags().Lookup("log.debug"))
 	_ = viper.BindEnv("log.debug", "LOG_DEBUG")
 	_ = viper.BindPFlag("log.json_output", rootCmd.PersistentFlags().Lookup("log.json-output"))
 	_ = viper.BindEnv("log.json_output", "LOG_JSON_OUTPUT")
 
+	for name := range exporter.Collectors() {
+		_ = viper.BindPFlag("collectors."+name, rootCmd.PersistentFlags().Lookup("collector."+name))
+		_ = viper.BindEnv("collectors."+name, "COLLECTOR_"+strings.ToUpper(name))
+	}
+
+	_ = viper.BindPFlag("nodes.addresses", rootCmd.PersistentFlags().Lookup("nodes.address"))
+	_ = viper.BindEnv("nodes.addresses", "NODES_ADDRESSES")
+
+	_ = viper.BindPFlag("metrics.namespace", rootCmd.PersistentFlags().Lookup("metrics.namespace"))
+	_ = viper.BindEnv("metrics.namespace", "METRICS_NAMESPACE")
+	_ = viper.BindPFlag("metrics.legacy_names", rootCmd.PersistentFlags().Lookup("metrics.legacy-names"))
+	_ = viper.BindEnv("metrics.legacy_names", "METRICS_LEGACY_NAMES")
+
 	_ = viper.BindPFlag("db.rethinkdb_addresses", rootCmd.PersistentFlags().Lookup("db.address"))
 	_ = viper.BindEnv("db.rethinkdb_addresses", "DB_ADDRESSES")
 	_ = viper.BindPFlag("db.username", rootCmd.PersistentFlags().Lookup("db.username"))
 	_ = viper.BindEnv("db.username", "DB_USERNAME")
 	_ = viper.BindPFlag("db.password", rootCmd.PersistentFlags().Lookup("db.password"))
 	_ = viper.BindEnv("db.password", "DB_PASSWORD")
+	_ = viper.BindPFlag("db.username_file", rootCmd.PersistentFlags().Lookup("db.username-file"))
+	_ = viper.BindEnv("db.username_file", "DB_USERNAME_FILE")
+	_ = viper.BindPFlag("db.password_file", rootCmd.PersistentFlags().Lookup("db.password-file"))
+	_ = viper.BindEnv("db.password_file", "DB_PASSWORD_FILE")
 	_ = viper.BindPFlag("db.enable_tls", rootCmd.PersistentFlags().Lookup("db.enable-tls"))
 	_ = viper.BindEnv("db.enable_tls", "DB_ENABLE_TLS")
 	_ = viper.BindPFlag("db.ca_file", rootCmd.PersistentFlags().Lookup("db.ca"))
@@ -95,14 +184,52 @@ func init() {
 	_ = viper.BindEnv("db.certificate_file", "DB_CERT")
 	_ = viper.BindPFlag("db.key_file", rootCmd.PersistentFlags().Lookup("db.key"))
 	_ = viper.BindEnv("db.key_file", "DB_KEY")
+	_ = viper.BindPFlag("db.tls_server_name", rootCmd.PersistentFlags().Lookup("db.tls-server-name"))
+	_ = viper.BindEnv("db.tls_server_name", "DB_TLS_SERVER_NAME")
+	_ = viper.BindPFlag("db.tls_insecure_skip_verify", rootCmd.PersistentFlags().Lookup("db.tls-insecure-skip-verify"))
+	_ = viper.BindEnv("db.tls_insecure_skip_verify", "DB_TLS_INSECURE_SKIP_VERIFY")
+	_ = viper.BindPFlag("db.tls_min_version", rootCmd.PersistentFlags().Lookup("db.tls-min-version"))
+	_ = viper.BindEnv("db.tls_min_version", "DB_TLS_MIN_VERSION")
+	_ = viper.BindPFlag("db.tls_max_version", rootCmd.PersistentFlags().Lookup("db.tls-max-version"))
+	_ = viper.BindEnv("db.tls_max_version", "DB_TLS_MAX_VERSION")
+	_ = viper.BindPFlag("db.tls_cipher_suites", rootCmd.PersistentFlags().Lookup("db.tls-cipher-suites"))
+	_ = viper.BindEnv("db.tls_cipher_suites", "DB_TLS_CIPHER_SUITES")
 	_ = viper.BindPFlag("db.connection_pool_size", rootCmd.PersistentFlags().Lookup("db.pool-size"))
 	_ = viper.BindEnv("db.connection_pool_size", "DB_POOL_SIZE")
 	_ = viper.BindPFlag("web.listen_address", rootCmd.PersistentFlags().Lookup("web.listen-address"))
 	_ = viper.BindEnv("web.listen_address", "WEB_LISTEN_ADDRESS")
 	_ = viper.BindPFlag("web.telemetry_path", rootCmd.PersistentFlags().Lookup("web.telemetry-path"))
 	_ = viper.BindEnv("web.TelemetryPath", "WEB_TELEMETRY_PATH")
+	_ = viper.BindPFlag("web.probe_path", rootCmd.PersistentFlags().Lookup("web.probe-path"))
+	_ = viper.BindEnv("web.probe_path", "WEB_PROBE_PATH")
+	_ = viper.BindPFlag("web.config_file", rootCmd.PersistentFlags().Lookup("web.config-file"))
+	_ = viper.BindEnv("web.config_file", "WEB_CONFIG_FILE")
+	_ = viper.BindPFlag("web.shutdown_grace_period", rootCmd.PersistentFlags().Lookup("web.shutdown-grace-period"))
+	_ = viper.BindEnv("web.shutdown_grace_period", "WEB_SHUTDOWN_GRACE_PERIOD")
 	_ = viper.BindPFlag("stats.table_docs_estimates", rootCmd.PersistentFlags().Lookup("stats.table-estimates"))
 	_ = viper.BindEnv("stats.table_docs_estimates", "STATS_TABLE_ESTIMATES")
+	_ = viper.BindPFlag("stats.table_info_workers", rootCmd.PersistentFlags().Lookup("stats.table-info-workers"))
+	_ = viper.BindEnv("stats.table_info_workers", "STATS_TABLE_INFO_WORKERS")
+	_ = viper.BindPFlag("stats.table_info_refresh_interval", rootCmd.PersistentFlags().Lookup("stats.table-info-refresh-interval"))
+	_ = viper.BindEnv("stats.table_info_refresh_interval", "STATS_TABLE_INFO_REFRESH_INTERVAL")
+	_ = viper.BindPFlag("stats.scrape_timeout", rootCmd.PersistentFlags().Lookup("stats.scrape-timeout"))
+	_ = viper.BindEnv("stats.scrape_timeout", "STATS_SCRAPE_TIMEOUT")
+	_ = viper.BindPFlag("stats.background_interval", rootCmd.PersistentFlags().Lookup("stats.background-interval"))
+	_ = viper.BindEnv("stats.background_interval", "STATS_BACKGROUND_INTERVAL")
+	_ = viper.BindPFlag("latency_probe.enabled", rootCmd.PersistentFlags().Lookup("latency-probe.enabled"))
+	_ = viper.BindEnv("latency_probe.enabled", "LATENCY_PROBE_ENABLED")
+	_ = viper.BindPFlag("latency_probe.interval", rootCmd.PersistentFlags().Lookup("latency-probe.interval"))
+	_ = viper.BindEnv("latency_probe.interval", "LATENCY_PROBE_INTERVAL")
+	_ = viper.BindPFlag("latency_probe.db", rootCmd.PersistentFlags().Lookup("latency-probe.db"))
+	_ = viper.BindEnv("latency_probe.db", "LATENCY_PROBE_DB")
+	_ = viper.BindPFlag("latency_probe.table", rootCmd.PersistentFlags().Lookup("latency-probe.table"))
+	_ = viper.BindEnv("latency_probe.table", "LATENCY_PROBE_TABLE")
+	_ = viper.BindPFlag("latency_probe.create_table", rootCmd.PersistentFlags().Lookup("latency-probe.create-table"))
+	_ = viper.BindEnv("latency_probe.create_table", "LATENCY_PROBE_CREATE_TABLE")
+	_ = viper.BindPFlag("latency_probe.durability", rootCmd.PersistentFlags().Lookup("latency-probe.durability"))
+	_ = viper.BindEnv("latency_probe.durability", "LATENCY_PROBE_DURABILITY")
+	_ = viper.BindPFlag("latency_probe.read_mode", rootCmd.PersistentFlags().Lookup("latency-probe.read-mode"))
+	_ = viper.BindEnv("latency_probe.read_mode", "LATENCY_PROBE_READ_MODE")
 
 	cobra.OnInitialize(initConfig)
 }
@@ -116,14 +243,110 @@ func initConfig() {
 		viper.SetConfigName("prometheus-exporter")
 	}
 
+	var err error
+	cfg, err = loadConfig()
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load config")
+	}
+}
+
+// loadConfig reads config file, flags and environment variables into a new config
+func loadConfig() (config.Config, error) {
+	var newCfg config.Config
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-			log.Fatal().Err(err).Msg("failed to read config file")
+			return newCfg, fmt.Errorf("failed to read config file: %v", err)
 		}
 	}
-	if err := viper.Unmarshal(&cfg); err != nil {
-		log.Fatal().Err(err).Msg("failed to parse config")
+	if err := viper.Unmarshal(&newCfg); err != nil {
+		return newCfg, fmt.Errorf("failed to parse config: %v", err)
+	}
+	return newCfg, nil
+}
+
+// customQueries converts custom queries from config to exporter
+func customQueries(queries []config.CustomQuery) []exporter.CustomQuery {
+	res := make([]exporter.CustomQuery, 0, len(queries))
+	for _, q := range queries {
+		res = append(res, exporter.CustomQuery{
+			Name:     q.Name,
+			Help:     q.Help,
+			Type:     q.Type,
+			Interval: q.Interval,
+			ReQL:     q.ReQL,
+			DB:       q.DB,
+			Table:    q.Table,
+			Filter:   q.Filter,
+			Group:    q.Group,
+			Count:    q.Count,
+			Labels:   q.Labels,
+			Value:    q.Value,
+		})
+	}
+	return res
+}
+
+// moduleTLSOptions converts TLS parameters of the module
+func moduleTLSOptions(module config.Module) dbconnector.TLSOptions {
+	return dbconnector.TLSOptions{
+		CAFile:             module.CAFile,
+		CertFile:           module.CertificateFile,
+		KeyFile:            module.KeyFile,
+		ServerName:         module.TLSServerName,
+		InsecureSkipVerify: module.TLSInsecureSkipVerify,
+		MinVersion:         module.TLSMinVersion,
+		MaxVersion:         module.TLSMaxVersion,
+		CipherSuites:       module.TLSCipherSuites,
+	}
+}
+
+// prepareProbeModules makes connection parameters of the probe modules.
+// Default module is made from DB parameters if it is not defined.
+func prepareProbeModules(cfg config.Config) (map[string]dbconnector.ModuleOpts, error) {
+	modules := make(map[string]config.Module, len(cfg.Modules)+1)
+	modules[exporter.DefaultProbeModule] = config.Module{
+		Username:              cfg.DB.Username,
+		Password:              cfg.DB.Password,
+		EnableTLS:             cfg.DB.EnableTLS,
+		CAFile:                cfg.DB.CAFile,
+		CertificateFile:       cfg.DB.CertificateFile,
+		KeyFile:               cfg.DB.KeyFile,
+		TLSServerName:         cfg.DB.TLSServerName,
+		TLSInsecureSkipVerify: cfg.DB.TLSInsecureSkipVerify,
+		TLSMinVersion:         cfg.DB.TLSMinVersion,
+		TLSMaxVersion:         cfg.DB.TLSMaxVersion,
+		TLSCipherSuites:       cfg.DB.TLSCipherSuites,
+		ConnectionPoolSize:    cfg.DB.ConnectionPoolSize,
+	}
+	for name, module := range cfg.Modules {
+		modules[name] = module
+	}
+
+	opts := make(map[string]dbconnector.ModuleOpts, len(modules))
+	for name, module := range modules {
+		var certs *dbconnector.CertificateReloader
+		if module.EnableTLS {
+			var err error
+			certs, err = dbconnector.PrepareTLSConfig(moduleTLSOptions(module))
+			if err != nil {
+				return nil, fmt.Errorf("module '%v': %v", name, err)
+			}
+		}
+
+		poolSize := module.ConnectionPoolSize
+		if poolSize == 0 {
+			poolSize = cfg.DB.ConnectionPoolSize
+		}
+
+		opts[name] = dbconnector.ModuleOpts{
+			Username:      module.Username,
+			Password:      module.Password,
+			TLS:           certs,
+			PoolSize:      poolSize,
+			CustomQueries: module.RunCustomQueries,
+		}
 	}
+	return opts, nil
 }
 
 func initLogging(cfg config.Config) {
@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 // Config defines the exporter's parameters
 type Config struct {
 	// Web defines http-server for prometheus protocol
@@ -8,14 +10,78 @@ type Config struct {
 		ListenAddress string `mapstructure:"listen_address"`
 		// TelemetryPath is http url path for metrics
 		TelemetryPath string `mapstructure:"telemetry_path"`
+		// ProbePath is http url path for probing targets, e.g. /probe?target=host:port&module=name
+		ProbePath string `mapstructure:"probe_path"`
+		// ConfigFile is a path to the web config with TLS and basic auth, reloaded when it is modified
+		ConfigFile string `mapstructure:"config_file"`
+		// ShutdownGracePeriod limits waiting for in-flight requests on shutdown
+		ShutdownGracePeriod time.Duration `mapstructure:"shutdown_grace_period"`
 	} `mapstructure:"web"`
 
 	// Stats defines collecting stats parameters
 	Stats struct {
 		// TableDocsEstimates tells the exporter to get table rows count estimates
 		TableDocsEstimates bool `mapstructure:"table_docs_estimates"`
+		// TableInfoWorkers limits number of concurrent queries of table rows count estimates
+		TableInfoWorkers int `mapstructure:"table_info_workers"`
+		// TableInfoRefreshInterval defines how long cached table rows count estimates are served
+		TableInfoRefreshInterval time.Duration `mapstructure:"table_info_refresh_interval"`
+		// ScrapeTimeout limits duration of collecting stats, zero means no limit
+		ScrapeTimeout time.Duration `mapstructure:"scrape_timeout"`
+		// BackgroundInterval enables collecting stats in background with the interval, scrapes get the last result
+		BackgroundInterval time.Duration `mapstructure:"background_interval"`
+
+		// Filters defines which databases, tables and servers are exported
+		Filters struct {
+			// DB filters databases by name
+			DB Filter `mapstructure:"db"`
+			// Table filters tables by full name "db.table"
+			Table Filter `mapstructure:"table"`
+			// Server filters servers by name
+			Server Filter `mapstructure:"server"`
+		} `mapstructure:"filters"`
 	} `mapstructure:"stats"`
 
+	// Collectors enables or disables collectors of system tables by name
+	Collectors map[string]bool `mapstructure:"collectors"`
+
+	// Nodes defines servers checked directly by nodes collector
+	Nodes struct {
+		// Addresses of the servers, discovered from server_status if empty
+		Addresses []string `mapstructure:"addresses"`
+	} `mapstructure:"nodes"`
+
+	// CustomQueries defines metrics collected with user defined ReQL queries
+	CustomQueries []CustomQuery `mapstructure:"custom_queries"`
+
+	// LatencyProbe defines synthetic probing of read and write latency with a probe table
+	LatencyProbe struct {
+		// Enabled starts the probe
+		Enabled bool `mapstructure:"enabled"`
+		// Interval between probes
+		Interval time.Duration `mapstructure:"interval"`
+		// DB is a database of the probe table
+		DB string `mapstructure:"db"`
+		// Table is a name of the probe table
+		Table string `mapstructure:"table"`
+		// CreateTable creates the database and the table if they don't exist
+		CreateTable bool `mapstructure:"create_table"`
+		// Durability of the probe writes: hard or soft
+		Durability string `mapstructure:"durability"`
+		// ReadMode of the probe reads: single, majority or outdated
+		ReadMode string `mapstructure:"read_mode"`
+	} `mapstructure:"latency_probe"`
+
+	// Metrics defines naming of exported metrics
+	Metrics struct {
+		// Namespace is a prefix of metrics names
+		Namespace string `mapstructure:"namespace"`
+		// LegacyNames keeps old unprefixed names of the metrics existed before the namespace for existing dashboards
+		LegacyNames bool `mapstructure:"legacy_names"`
+		// ConstLabels are added to all exported metrics, e.g. cluster name
+		ConstLabels map[string]string `mapstructure:"const_labels"`
+	} `mapstructure:"metrics"`
+
 	// DB defines rethinkdb-connection parameters
 	DB struct {
 		// RethinkdbAddresses list of endpoints of the rethinkdb nodes to connect
@@ -25,6 +91,10 @@ type Config struct {
 		Username string `mapstructure:"username"`
 		// Password to auth in the rethinkdb
 		Password string `mapstructure:"password"`
+		// UsernameFile is a path to the file with username, it overrides Username and is watched for changes
+		UsernameFile string `mapstructure:"username_file"`
+		// PasswordFile is a path to the file with password, it overrides Password and is watched for changes
+		PasswordFile string `mapstructure:"password_file"`
 
 		// EnableTLS enables encryption on the connection
 		EnableTLS bool `mapstructure:"enable_tls"`
@@ -34,11 +104,26 @@ type Config struct {
 		CertificateFile string `mapstructure:"certificate_file"`
 		// KeyFile locates path of the key file to the client certificate
 		KeyFile string `mapstructure:"key_file"`
+		// TLSServerName is verified in the server certificate instead of the address host
+		TLSServerName string `mapstructure:"tls_server_name"`
+		// TLSInsecureSkipVerify disables verification of the server certificate, only for testing
+		TLSInsecureSkipVerify bool `mapstructure:"tls_insecure_skip_verify"`
+		// TLSMinVersion is the minimum TLS version: TLS10, TLS11, TLS12 or TLS13
+		TLSMinVersion string `mapstructure:"tls_min_version"`
+		// TLSMaxVersion is the maximum TLS version: TLS10, TLS11, TLS12 or TLS13
+		TLSMaxVersion string `mapstructure:"tls_max_version"`
+		// TLSCipherSuites are names of the enabled cipher suites for TLS 1.2 and lower
+		TLSCipherSuites []string `mapstructure:"tls_cipher_suites"`
 
 		// ConnectionPoolSize defines size of the connection pool to the rethinkdb
 		ConnectionPoolSize int `mapstructure:"connection_pool_size"`
 	} `mapstructure:"db"`
 
+	// Modules defines named rethinkdb-connection parameters for probing targets.
+	// Module "default" is used when module is not set in the probe request,
+	// it falls back to DB parameters if not defined.
+	Modules map[string]Module `mapstructure:"modules"`
+
 	// Log defines exporter's logging
 	Log struct {
 		// JSONOutput enables output in json-format, use for structured logging
@@ -47,3 +132,76 @@ type Config struct {
 		Debug bool `mapstructure:"debug"`
 	} `mapstructure:"log"`
 }
+
+// Filter defines regexps matching whole names, exclude takes precedence and empty include matches all
+type Filter struct {
+	// Include lists regexps of names to export
+	Include []string `mapstructure:"include"`
+	// Exclude lists regexps of names to skip
+	Exclude []string `mapstructure:"exclude"`
+}
+
+// CustomQuery defines a metric collected with user defined ReQL query.
+// Query is either JSON serialised ReQL term or a subset of table, filter, group and count terms.
+type CustomQuery struct {
+	// Name of the metric
+	Name string `mapstructure:"name"`
+	// Help of the metric
+	Help string `mapstructure:"help"`
+	// Type of the metric: gauge or counter
+	Type string `mapstructure:"type"`
+	// Interval of running the query, zero means every scrape
+	Interval time.Duration `mapstructure:"interval"`
+
+	// ReQL is JSON serialised ReQL term
+	ReQL string `mapstructure:"reql"`
+
+	// DB is a database of the table
+	DB string `mapstructure:"db"`
+	// Table to query
+	Table string `mapstructure:"table"`
+	// Filter selects documents with fields equal to the values
+	Filter map[string]interface{} `mapstructure:"filter"`
+	// Group groups documents by the field
+	Group string `mapstructure:"group"`
+	// Count counts documents
+	Count bool `mapstructure:"count"`
+
+	// Labels maps label names to fields of the result documents
+	Labels map[string]string `mapstructure:"labels"`
+	// Value is the field of the result documents with the metric value
+	Value string `mapstructure:"value"`
+}
+
+// Module defines rethinkdb-connection parameters of probed targets
+type Module struct {
+	// Username to auth in the rethinkdb
+	Username string `mapstructure:"username"`
+	// Password to auth in the rethinkdb
+	Password string `mapstructure:"password"`
+
+	// EnableTLS enables encryption on the connection
+	EnableTLS bool `mapstructure:"enable_tls"`
+	// CAFile locates path of the CA file
+	CAFile string `mapstructure:"ca_file"`
+	// CertificateFile locates path of the client certificate file
+	CertificateFile string `mapstructure:"certificate_file"`
+	// KeyFile locates path of the key file to the client certificate
+	KeyFile string `mapstructure:"key_file"`
+	// TLSServerName is verified in the server certificate instead of the address host
+	TLSServerName string `mapstructure:"tls_server_name"`
+	// TLSInsecureSkipVerify disables verification of the server certificate, only for testing
+	TLSInsecureSkipVerify bool `mapstructure:"tls_insecure_skip_verify"`
+	// TLSMinVersion is the minimum TLS version: TLS10, TLS11, TLS12 or TLS13
+	TLSMinVersion string `mapstructure:"tls_min_version"`
+	// TLSMaxVersion is the maximum TLS version: TLS10, TLS11, TLS12 or TLS13
+	TLSMaxVersion string `mapstructure:"tls_max_version"`
+	// TLSCipherSuites are names of the enabled cipher suites for TLS 1.2 and lower
+	TLSCipherSuites []string `mapstructure:"tls_cipher_suites"`
+
+	// ConnectionPoolSize defines size of the connection pool to the rethinkdb
+	ConnectionPoolSize int `mapstructure:"connection_pool_size"`
+
+	// RunCustomQueries enables custom queries against probed targets
+	RunCustomQueries bool `mapstructure:"run_custom_queries"`
+}
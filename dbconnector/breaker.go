@@ -0,0 +1,205 @@
+package dbconnector
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+const (
+	// breakerFailureThreshold is a number of consecutive connection failures opening the circuit breaker
+	breakerFailureThreshold = 3
+	// breakerBackoffBase is the first delay before the next connect attempt, it is doubled after every failed attempt
+	breakerBackoffBase = time.Second
+	// breakerBackoffMax limits the delay before the next connect attempt
+	breakerBackoffMax = time.Minute
+)
+
+// ErrCircuitOpen is returned without connecting while the circuit breaker is open
+var ErrCircuitOpen = errors.New("rethinkdb circuit breaker is open, waiting before the next connect attempt")
+
+// states of the circuit breaker
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// breaker fails fast after consecutive connection failures.
+// It is open for the exponential backoff with jitter, then a single attempt is allowed in half-open state:
+// the breaker is closed if it succeeds or open again with the doubled backoff if it fails.
+type breaker struct {
+	m        sync.Mutex
+	state    string
+	failures int
+	// attempts is a number of failed attempts since the breaker was opened, it defines the backoff
+	attempts int
+	retryAt  time.Time
+	trial    bool
+	// rand is seeded per breaker to spread reconnects of several exporters
+	rand *rand.Rand
+}
+
+func newBreaker() *breaker {
+	return &breaker{
+		state: breakerClosed,
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// allow returns ErrCircuitOpen if the call must fail fast.
+// After the backoff the breaker is half-open and allows the only trial call until its result is recorded.
+func (b *breaker) allow() error {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Now().Before(b.retryAt) {
+			return ErrCircuitOpen
+		}
+		b.state = breakerHalfOpen
+		b.trial = true
+		return nil
+	case breakerHalfOpen:
+		if b.trial {
+			return ErrCircuitOpen
+		}
+		b.trial = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// isOpen returns true if calls must fail fast without starting a trial
+func (b *breaker) isOpen() bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return b.state == breakerOpen && time.Now().Before(b.retryAt)
+}
+
+// success closes the breaker
+func (b *breaker) success() {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if b.state != breakerClosed {
+		log.Info().Msg("rethinkdb connection restored, circuit breaker closed")
+	}
+	b.state = breakerClosed
+	b.failures = 0
+	b.attempts = 0
+	b.trial = false
+}
+
+// failure counts the connection failure and opens the breaker after the threshold or the failed trial
+func (b *breaker) failure(err error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.failures++
+	b.trial = false
+	if b.state == breakerOpen && time.Now().Before(b.retryAt) {
+		// failure of the call started before the breaker was opened
+		return
+	}
+	if b.state == breakerClosed && b.failures < breakerFailureThreshold {
+		return
+	}
+
+	delay := b.backoff(b.attempts)
+	b.attempts++
+	b.retryAt = time.Now().Add(delay)
+	if b.state == breakerClosed {
+		log.Warn().Err(err).Dur("retry_in", delay).Msg("rethinkdb is unreachable, circuit breaker opened")
+	} else {
+		log.Debug().Err(err).Dur("retry_in", delay).Msg("rethinkdb connect attempt failed, circuit breaker is still open")
+	}
+	b.state = breakerOpen
+}
+
+// reset closes the breaker without logging, e.g. when connection parameters are changed
+func (b *breaker) reset() {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.state = breakerClosed
+	b.failures = 0
+	b.attempts = 0
+	b.trial = false
+}
+
+// cancel releases the trial which was interrupted before its result is known
+func (b *breaker) cancel() {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.trial = false
+}
+
+// currentState returns the state of the breaker, it is half-open when the backoff is over
+func (b *breaker) currentState() string {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if b.state == breakerOpen && !time.Now().Before(b.retryAt) {
+		return breakerHalfOpen
+	}
+	return b.state
+}
+
+// backoff returns the exponential delay of the attempt with equal jitter:
+// a half of the delay is fixed and the other half is random. It is called with the lock held.
+func (b *breaker) backoff(attempt int) time.Duration {
+	delay := breakerBackoffMax
+	if attempt < 16 {
+		delay = breakerBackoffBase << uint(attempt)
+		if delay > breakerBackoffMax {
+			delay = breakerBackoffMax
+		}
+	}
+	half := delay / 2
+	return half + time.Duration(b.rand.Int63n(int64(half)+1))
+}
+
+// isConnectionError returns true if the error means the connection is broken and the session should reconnect
+func isConnectionError(err error) bool {
+	for ; err != nil; err = unwrapError(err) {
+		switch err {
+		case r.ErrConnectionClosed, r.ErrNoConnections, r.ErrNoConnectionsStarted,
+			io.EOF, io.ErrUnexpectedEOF, syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.EPIPE:
+			return true
+		}
+		// timeouts are dial, handshake and write deadlines, queries cancelled by their context are not recorded at all
+		switch err.(type) {
+		case net.Error, r.RQLConnectionError:
+			return true
+		}
+	}
+	return false
+}
+
+// unwrapError returns the error wrapped by net, os or wrapping errors, nil if there is none.
+// It doesn't use errors.Unwrap of Go 1.13 to build with older Go versions.
+func unwrapError(err error) error {
+	switch e := err.(type) {
+	case *net.OpError:
+		return e.Err
+	case *os.SyscallError:
+		return e.Err
+	case interface{ Unwrap() error }:
+		return e.Unwrap()
+	default:
+		return nil
+	}
+}
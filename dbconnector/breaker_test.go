@@ -0,0 +1,163 @@
+package dbconnector
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+var errTest = errors.New("test error")
+
+func TestBreakerStates(t *testing.T) {
+	b := newBreaker()
+	for i := 1; i < breakerFailureThreshold; i++ {
+		b.failure(errTest)
+		if state := b.currentState(); state != breakerClosed {
+			t.Fatalf("state after %d failures = %v, want %v", i, state, breakerClosed)
+		}
+		if err := b.allow(); err != nil {
+			t.Fatalf("allow() after %d failures = %v", i, err)
+		}
+	}
+
+	b.failure(errTest)
+	if state := b.currentState(); state != breakerOpen {
+		t.Fatalf("state after threshold = %v, want %v", state, breakerOpen)
+	}
+	if err := b.allow(); err != ErrCircuitOpen {
+		t.Fatalf("allow() while open = %v, want %v", err, ErrCircuitOpen)
+	}
+	if !b.isOpen() {
+		t.Fatal("isOpen() = false while open")
+	}
+
+	// backoff is over
+	b.retryAt = time.Now()
+	if state := b.currentState(); state != breakerHalfOpen {
+		t.Fatalf("state after backoff = %v, want %v", state, breakerHalfOpen)
+	}
+	if err := b.allow(); err != nil {
+		t.Fatalf("allow() of the trial = %v", err)
+	}
+	if err := b.allow(); err != ErrCircuitOpen {
+		t.Fatalf("allow() during the trial = %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// failed trial opens the breaker again with the next backoff
+	b.failure(errTest)
+	if state := b.currentState(); state != breakerOpen {
+		t.Fatalf("state after failed trial = %v, want %v", state, breakerOpen)
+	}
+	if b.attempts != 2 {
+		t.Fatalf("attempts after failed trial = %v, want 2", b.attempts)
+	}
+
+	// cancelled trial allows another one
+	b.retryAt = time.Now()
+	if err := b.allow(); err != nil {
+		t.Fatalf("allow() of the trial = %v", err)
+	}
+	b.cancel()
+	if err := b.allow(); err != nil {
+		t.Fatalf("allow() after cancelled trial = %v", err)
+	}
+
+	b.success()
+	if state := b.currentState(); state != breakerClosed {
+		t.Fatalf("state after success = %v, want %v", state, breakerClosed)
+	}
+	if b.failures != 0 || b.attempts != 0 {
+		t.Fatalf("failures, attempts after success = %v, %v, want 0, 0", b.failures, b.attempts)
+	}
+}
+
+func TestBreakerReset(t *testing.T) {
+	b := newBreaker()
+	for i := 0; i < breakerFailureThreshold; i++ {
+		b.failure(errTest)
+	}
+	b.reset()
+	if state := b.currentState(); state != breakerClosed {
+		t.Fatalf("state after reset = %v, want %v", state, breakerClosed)
+	}
+	if err := b.allow(); err != nil {
+		t.Fatalf("allow() after reset = %v", err)
+	}
+}
+
+func TestBreakerBackoff(t *testing.T) {
+	b := newBreaker()
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 0, max: time.Second},
+		{attempt: 1, max: 2 * time.Second},
+		{attempt: 3, max: 8 * time.Second},
+		{attempt: 6, max: breakerBackoffMax},
+		{attempt: 16, max: breakerBackoffMax},
+		{attempt: 100, max: breakerBackoffMax},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.attempt), func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				delay := b.backoff(tt.attempt)
+				if delay < tt.max/2 || delay > tt.max {
+					t.Fatalf("backoff(%d) = %v, want within [%v, %v]", tt.attempt, delay, tt.max/2, tt.max)
+				}
+			}
+		})
+	}
+}
+
+// wrappedError wraps the error like fmt.Errorf with %w
+type wrappedError struct {
+	err error
+}
+
+func (e wrappedError) Error() string { return "wrapped: " + e.err.Error() }
+func (e wrappedError) Unwrap() error { return e.err }
+
+// testNetError is a net.Error with configurable timeout
+type testNetError struct {
+	timeout bool
+}
+
+func (e testNetError) Error() string   { return "test net error" }
+func (e testNetError) Timeout() bool   { return e.timeout }
+func (e testNetError) Temporary() bool { return e.timeout }
+
+func TestIsConnectionError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "query error", err: errTest, want: false},
+		{name: "connection closed", err: r.ErrConnectionClosed, want: true},
+		{name: "no connections", err: r.ErrNoConnections, want: true},
+		{name: "eof", err: io.EOF, want: true},
+		{name: "wrapped reset", err: wrappedError{syscall.ECONNRESET}, want: true},
+		{name: "syscall reset", err: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, want: true},
+		{name: "wrapped query error", err: wrappedError{errTest}, want: false},
+		{name: "refused", err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, want: true},
+		{name: "net error", err: testNetError{}, want: true},
+		{name: "write timeout", err: &net.OpError{Op: "write", Err: testNetError{timeout: true}}, want: true},
+		{name: "timeout", err: testNetError{timeout: true}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isConnectionError(tt.err); got != tt.want {
+				t.Errorf("isConnectionError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
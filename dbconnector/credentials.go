@@ -0,0 +1,76 @@
+package dbconnector
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// credentialsCheckInterval is a period of checking credential files for changes
+const credentialsCheckInterval = 10 * time.Second
+
+// ReadCredentials returns username and password read from the files if they are set, otherwise the given ones.
+// Trailing newlines of the files are trimmed.
+func ReadCredentials(username, password, usernameFile, passwordFile string) (string, string, error) {
+	var err error
+	if usernameFile != "" {
+		username, err = readSecretFile(usernameFile)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read username file: %v", err)
+		}
+	}
+	if passwordFile != "" {
+		password, err = readSecretFile(passwordFile)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read password file: %v", err)
+		}
+	}
+	return username, password, nil
+}
+
+func readSecretFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// WatchCredentials checks credential files until ctx is done and reconnects with new credentials when they change.
+// Files are polled because secrets of kubernetes and vault agent are replaced by renaming.
+// Credentials without a file keep their current value. onChange, if not nil, is called with the new credentials.
+func (l *LazyRethinkSession) WatchCredentials(ctx context.Context, usernameFile, passwordFile string, onChange func(username, password string)) {
+	ticker := time.NewTicker(credentialsCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		l.m.Lock()
+		curUsername, curPassword := l.opts.Username, l.opts.Password
+		l.m.Unlock()
+
+		username, password, err := ReadCredentials(curUsername, curPassword, usernameFile, passwordFile)
+		if err != nil {
+			log.Warn().Err(err).Msg("failed to read rethinkdb credentials, keeping the current ones")
+			continue
+		}
+		if username == curUsername && password == curPassword {
+			continue
+		}
+
+		log.Info().Msg("rethinkdb credentials changed, reconnecting")
+		l.SetCredentials(username, password)
+		if onChange != nil {
+			onChange(username, password)
+		}
+	}
+}
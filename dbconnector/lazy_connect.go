@@ -3,124 +3,355 @@ package dbconnector
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
 )
 
+// connectTimeout limits dialing, TLS and rethinkdb handshakes of a new session.
+// Read timeout is not set, it would break idle pooled connections, queries are limited by their context.
+const connectTimeout = 10 * time.Second
+
+// errConnectTimeout is returned if a new session is not connected within connectTimeout
+var errConnectTimeout = errors.New("timed out connecting to rethinkdb")
+
 // ConnectRethinkDB establishes lazy rethinkdb connection
-// It will make attempt to connect with first call and reconnect after every error
+// It will make attempt to connect with first call and reconnect after connection errors
 func ConnectRethinkDB(
 	addresses []string,
 	username, password string,
-	tlsConfig *tls.Config,
+	certs *CertificateReloader,
 	poolSize int,
 ) *LazyRethinkSession {
 	const systemDatabase = "rethinkdb"
 
 	return &LazyRethinkSession{
+		queryBuilder: r.NewMock(r.ConnectOpts{Database: systemDatabase}),
 		opts: r.ConnectOpts{
-			Addresses: addresses,
-			Database:  systemDatabase,
-			Username:  username,
-			Password:  password,
-			TLSConfig: tlsConfig,
-			MaxOpen:   poolSize,
+			Addresses:    addresses,
+			Database:     systemDatabase,
+			Username:     username,
+			Password:     password,
+			MaxOpen:      poolSize,
+			Timeout:      connectTimeout,
+			WriteTimeout: connectTimeout,
 		},
+		certs:   certs,
+		breaker: newBreaker(),
 	}
 }
 
+// queryBuilder builds queries run with the lazy session. r.QueryExecutor has an unexported method building queries,
+// so it is implemented by embedding r.Mock which builds them with the database like the session does.
+// The alias keeps the embedded field unexported, Query, Exec and IsConnected are shadowed by LazyRethinkSession.
+type queryBuilder = r.QueryExecutor
+
 // LazyRethinkSession is a connection to the rethinkdb.
 // It implements r.QueryExecutor interface.
-// It will make attempt to connect with first call and reconnect after every error.
+// It will make attempt to connect with first call and reconnect after connection errors.
+// Consecutive connection failures open the circuit breaker, queries fail fast with ErrCircuitOpen until the backoff is over.
 type LazyRethinkSession struct {
-	*r.Session
+	// counters are first to be aligned for atomic operations
+	reconnects      uint64
+	connectFailures uint64
+
+	queryBuilder
+
+	sess   *r.Session
+	closed bool
+	// connecting is the running connect attempt shared by concurrent queries, nil if there is none
+	connecting *connectAttempt
+	opts       r.ConnectOpts
+	// certs builds TLS config of every new session, nil without TLS
+	certs   *CertificateReloader
+	m       sync.Mutex
+	breaker *breaker
+}
 
-	opts r.ConnectOpts
-	m    sync.Mutex
+// connectAttempt is a connect running without the lock, done is closed when sess or err is set
+type connectAttempt struct {
+	done chan struct{}
+	sess *r.Session
+	err  error
 }
 
 // Close closes connections
 func (l *LazyRethinkSession) Close() error {
-	if l.Session != nil {
-		return l.Session.Close()
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.closed = true
+	l.connecting = nil
+	if l.sess != nil {
+		return l.sess.Close()
 	}
 	return nil
 }
 
-// IsConnected returns true if session has a valid connection.
+// IsConnected returns false only after the session is closed.
+// The driver checks it before every query, so connecting is left to Query and Exec:
+// they wait for the connection within the query context and fail with ErrCircuitOpen while the circuit breaker is open.
 func (l *LazyRethinkSession) IsConnected() bool {
-	if l.Session == nil {
-		err := l.connect()
-		if err != nil {
-			log.Warn().Err(err).Msg("failed to connect to rethinkdb")
-			return false
-		}
-	}
+	l.m.Lock()
+	defer l.m.Unlock()
 
-	is := l.Session.IsConnected()
-	if !is {
-		err := l.Session.Reconnect()
-		if err != nil {
-			return false
-		}
-		is = l.Session.IsConnected()
-	}
-	return is
+	return !l.closed
 }
 
 // Query executes a ReQL query using the session to connect to the database
 func (l *LazyRethinkSession) Query(ctx context.Context, q r.Query) (*r.Cursor, error) {
-	if l.Session == nil {
-		err := l.connect()
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	cur, err := l.Session.Query(ctx, q)
-	if err == r.ErrConnectionClosed {
-		err = l.Session.Reconnect()
-		if err != nil {
-			return nil, err
-		}
-		cur, err = l.Session.Query(ctx, q)
-	}
+	var cur *r.Cursor
+	err := l.do(ctx, func(sess *r.Session) error {
+		var err error
+		cur, err = sess.Query(ctx, q)
+		return err
+	})
 	return cur, err
 }
 
 // Exec executes a ReQL query using the session to connect to the database
 func (l *LazyRethinkSession) Exec(ctx context.Context, q r.Query) error {
-	if l.Session == nil {
-		err := l.connect()
-		if err != nil {
-			return err
+	return l.do(ctx, func(sess *r.Session) error {
+		return sess.Exec(ctx, q)
+	})
+}
+
+// BreakerState returns the state of the circuit breaker: closed, open or half_open
+func (l *LazyRethinkSession) BreakerState() string {
+	return l.breaker.currentState()
+}
+
+// Reconnects returns the number of reconnects after connection errors
+func (l *LazyRethinkSession) Reconnects() uint64 {
+	return atomic.LoadUint64(&l.reconnects)
+}
+
+// ConnectFailures returns the number of failed connect attempts
+func (l *LazyRethinkSession) ConnectFailures() uint64 {
+	return atomic.LoadUint64(&l.connectFailures)
+}
+
+// do runs the query function with the session, it reconnects and retries once after a connection error.
+// Results are recorded by the circuit breaker, queries cancelled by ctx are not counted.
+func (l *LazyRethinkSession) do(ctx context.Context, query func(*r.Session) error) error {
+	sess, err := l.session(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			// the trial is still connecting, its result is recorded by the attempt
+			l.breaker.cancel()
 		}
+		return err
 	}
 
-	err := l.Session.Exec(ctx, q)
-	if err == r.ErrConnectionClosed {
-		err = l.Session.Reconnect()
+	err = query(sess)
+	if isConnectionError(err) && ctx.Err() == nil {
+		log.Debug().Err(err).Msg("rethinkdb connection error, reconnecting")
+		sess, err = l.reconnect(ctx, sess)
 		if err != nil {
 			return err
 		}
-		err = l.Session.Exec(ctx, q)
+		err = query(sess)
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		l.breaker.cancel()
+	case isConnectionError(err):
+		l.breaker.failure(err)
+	default:
+		l.breaker.success()
 	}
 	return err
 }
 
-func (l *LazyRethinkSession) connect() error {
+// SetCredentials replaces username and password of the session.
+// Current connections are closed, next query connects with the new credentials.
+func (l *LazyRethinkSession) SetCredentials(username, password string) {
 	l.m.Lock()
-	defer l.m.Unlock()
+	l.opts.Username = username
+	l.opts.Password = password
+	sess := l.sess
+	l.sess = nil
+	// the running attempt uses old credentials, its session is discarded
+	l.connecting = nil
+	// new credentials are tried without waiting for the backoff
+	l.breaker.reset()
+	l.m.Unlock()
 
-	var err error
-	if l.Session == nil {
-		l.Session, err = r.Connect(l.opts)
-		if err != nil {
-			// to connect at next attempt
-			l.Session = nil
+	if sess != nil {
+		if err := sess.Close(); err != nil {
+			log.Warn().Err(err).Msg("failed to close rethinkdb session with old credentials")
 		}
 	}
-	return err
+}
+
+// DialNode opens a new session to the single node address with parameters of the session.
+// Session is not shared with queries of the lazy session, caller must close it.
+func (l *LazyRethinkSession) DialNode(ctx context.Context, address string) (*r.Session, error) {
+	l.m.Lock()
+	opts := l.opts
+	l.m.Unlock()
+
+	opts.TLSConfig = l.tlsConfig()
+	opts.Addresses = []string{address}
+	opts.MaxOpen = 1
+	opts.InitialCap = 1
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+		opts.Timeout = timeout
+		opts.ReadTimeout = timeout
+		opts.WriteTimeout = timeout
+	}
+	return connectWithTimeout(ctx, opts)
+}
+
+// session returns the current session, it connects if there is no session yet.
+// It fails fast with ErrCircuitOpen while the circuit breaker is open, after the backoff only the trial query is allowed.
+// Waiting for the connection is limited by ctx.
+func (l *LazyRethinkSession) session(ctx context.Context) (*r.Session, error) {
+	l.m.Lock()
+	if l.closed {
+		l.m.Unlock()
+		return nil, r.ErrConnectionClosed
+	}
+	err := l.breaker.allow()
+	if err != nil {
+		l.m.Unlock()
+		return nil, err
+	}
+	if sess := l.sess; sess != nil {
+		l.m.Unlock()
+		return sess, nil
+	}
+	attempt := l.startConnect()
+	l.m.Unlock()
+
+	return attempt.wait(ctx)
+}
+
+// reconnect replaces the failed session with a new one unless it is already replaced by another query or new credentials
+func (l *LazyRethinkSession) reconnect(ctx context.Context, failed *r.Session) (*r.Session, error) {
+	l.m.Lock()
+	if l.closed {
+		l.m.Unlock()
+		return nil, r.ErrConnectionClosed
+	}
+	if sess := l.sess; sess != nil && sess != failed {
+		l.m.Unlock()
+		return sess, nil
+	}
+	if l.breaker.isOpen() {
+		l.m.Unlock()
+		return nil, ErrCircuitOpen
+	}
+
+	atomic.AddUint64(&l.reconnects, 1)
+	if l.sess != nil {
+		// pool of the failed session is replaced, close errors are expected
+		_ = l.sess.Close()
+		l.sess = nil
+	}
+	attempt := l.startConnect()
+	l.m.Unlock()
+
+	return attempt.wait(ctx)
+}
+
+// startConnect returns the running connect attempt or starts a new one, it is called with the lock held
+func (l *LazyRethinkSession) startConnect() *connectAttempt {
+	if l.connecting != nil {
+		return l.connecting
+	}
+	attempt := &connectAttempt{done: make(chan struct{})}
+	l.connecting = attempt
+	go l.connect(attempt, l.opts)
+	return attempt
+}
+
+// connect creates a new session without the lock.
+// Failures are counted by the circuit breaker, results of queries decide if the connection is healthy.
+func (l *LazyRethinkSession) connect(attempt *connectAttempt, opts r.ConnectOpts) {
+	opts.TLSConfig = l.tlsConfig()
+	sess, err := connectWithTimeout(context.Background(), opts)
+
+	l.m.Lock()
+	switch {
+	case l.connecting != attempt:
+		// credentials are changed or the session is closed while connecting
+		if sess != nil {
+			_ = sess.Close()
+		}
+		sess, err = nil, r.ErrConnectionClosed
+	case err != nil:
+		atomic.AddUint64(&l.connectFailures, 1)
+		l.breaker.failure(err)
+	default:
+		l.sess = sess
+	}
+	if l.connecting == attempt {
+		l.connecting = nil
+	}
+	l.m.Unlock()
+
+	attempt.sess, attempt.err = sess, err
+	close(attempt.done)
+}
+
+// tlsConfig returns TLS config with the current CA for a new session, nil without TLS
+func (l *LazyRethinkSession) tlsConfig() *tls.Config {
+	if l.certs == nil {
+		return nil
+	}
+	return l.certs.TLSConfig()
+}
+
+// wait returns the result of the attempt or the error of ctx if it is done first
+func (a *connectAttempt) wait(ctx context.Context) (*r.Session, error) {
+	select {
+	case <-a.done:
+		return a.sess, a.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// connectWithTimeout connects within connectTimeout or until ctx is done.
+// Rethinkdb handshake of the driver has no deadline, the session of the abandoned connect is closed when it is done.
+func connectWithTimeout(ctx context.Context, opts r.ConnectOpts) (*r.Session, error) {
+	type result struct {
+		sess *r.Session
+		err  error
+	}
+	res := make(chan result, 1)
+	go func() {
+		sess, err := r.Connect(opts)
+		res <- result{sess: sess, err: err}
+	}()
+
+	timer := time.NewTimer(connectTimeout)
+	defer timer.Stop()
+	abandon := func() {
+		go func() {
+			if res := <-res; res.sess != nil {
+				_ = res.sess.Close()
+			}
+		}()
+	}
+	select {
+	case res := <-res:
+		return res.sess, res.err
+	case <-timer.C:
+		abandon()
+		return nil, errConnectTimeout
+	case <-ctx.Done():
+		abandon()
+		return nil, ctx.Err()
+	}
 }
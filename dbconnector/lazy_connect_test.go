@@ -0,0 +1,113 @@
+package dbconnector
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+// silentListener accepts connections and never answers the handshake, it returns the address and the listener shutdown
+func silentListener(t *testing.T) (string, func()) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conns []net.Conn
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	return ln.Addr().String(), func() {
+		ln.Close()
+		<-done
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+}
+
+func TestLazySessionConnectCancelledByContext(t *testing.T) {
+	address, shutdown := silentListener(t)
+	defer shutdown()
+
+	l := ConnectRethinkDB([]string{address}, "admin", "", nil, 1)
+	defer l.Close()
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			errs <- l.Exec(ctx, r.Query{})
+		}()
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if err != context.DeadlineExceeded {
+				t.Fatalf("Exec() error = %v, want %v", err, context.DeadlineExceeded)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Exec() is not cancelled by its context while connecting")
+		}
+	}
+	if state := l.BreakerState(); state != breakerClosed {
+		t.Fatalf("breaker state after cancelled queries = %v, want %v", state, breakerClosed)
+	}
+}
+
+func TestDialNodeCancelledByContext(t *testing.T) {
+	address, shutdown := silentListener(t)
+	defer shutdown()
+
+	l := ConnectRethinkDB(nil, "admin", "", nil, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := l.DialNode(ctx, address)
+	if err == nil {
+		t.Fatal("DialNode() succeeded without handshake")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("DialNode() took %v after the context deadline", elapsed)
+	}
+}
+
+func TestLazySessionCircuitOpen(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+
+	l := ConnectRethinkDB([]string{address}, "admin", "", nil, 1)
+	defer l.Close()
+
+	ctx := context.Background()
+	for i := 0; i < breakerFailureThreshold; i++ {
+		err := r.Expr(1).Exec(l, r.ExecOpts{Context: ctx})
+		if err == nil || err == ErrCircuitOpen {
+			t.Fatalf("Exec() attempt %d error = %v, want connection error", i, err)
+		}
+	}
+	if err := r.Expr(1).Exec(l, r.ExecOpts{Context: ctx}); err != ErrCircuitOpen {
+		t.Fatalf("Exec() with open breaker error = %v, want %v", err, ErrCircuitOpen)
+	}
+	if failures := l.ConnectFailures(); failures != breakerFailureThreshold {
+		t.Fatalf("ConnectFailures() = %v, want %v", failures, breakerFailureThreshold)
+	}
+}
@@ -0,0 +1,162 @@
+package dbconnector
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+const (
+	// probeSessionIdleTimeout is duration after that unused probe session is closed
+	probeSessionIdleTimeout = 10 * time.Minute
+	// maxProbeSessions limits the number of cached sessions, targets are arbitrary strings of the probe requests
+	maxProbeSessions = 100
+)
+
+// ModuleOpts defines connection parameters of the probe module
+type ModuleOpts struct {
+	Username string
+	Password string
+	// TLS builds TLS config of the sessions, nil without TLS
+	TLS      *CertificateReloader
+	PoolSize int
+	// CustomQueries enables custom queries against targets of the module
+	CustomQueries bool
+}
+
+// ProbeSessions builds and reuses lazy rethinkdb sessions to probed targets.
+// Sessions are cached by target address and module name, idle sessions are closed.
+// The least recently used session is closed when the cache is full.
+type ProbeSessions struct {
+	m        sync.Mutex
+	modules  map[string]ModuleOpts
+	sessions map[probeSessionKey]*probeSession
+}
+
+type probeSessionKey struct {
+	target string
+	module string
+}
+
+type probeSession struct {
+	*LazyRethinkSession
+	lastUsed time.Time
+}
+
+// NewProbeSessions creates sessions cache for probing targets with the modules
+func NewProbeSessions(modules map[string]ModuleOpts) *ProbeSessions {
+	return &ProbeSessions{
+		modules:  modules,
+		sessions: make(map[probeSessionKey]*probeSession),
+	}
+}
+
+// Connect returns cached or new lazy session to the target with parameters of the module
+func (p *ProbeSessions) Connect(target, module string) (r.QueryExecutor, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	opts, ok := p.modules[module]
+	if !ok {
+		return nil, fmt.Errorf("unknown module '%v'", module)
+	}
+
+	now := time.Now()
+	p.closeIdle(now)
+
+	key := probeSessionKey{target: target, module: module}
+	sess, ok := p.sessions[key]
+	if !ok {
+		if len(p.sessions) >= maxProbeSessions {
+			p.closeLeastRecentlyUsed()
+		}
+		sess = &probeSession{
+			LazyRethinkSession: ConnectRethinkDB(
+				[]string{target},
+				opts.Username,
+				opts.Password,
+				opts.TLS,
+				opts.PoolSize,
+			),
+		}
+		p.sessions[key] = sess
+	}
+	sess.lastUsed = now
+
+	return sess.LazyRethinkSession, nil
+}
+
+// CustomQueries tells if custom queries run against targets of the module
+func (p *ProbeSessions) CustomQueries(module string) bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return p.modules[module].CustomQueries
+}
+
+// SetCredentials replaces credentials of the module, cached sessions of the module reconnect with them
+func (p *ProbeSessions) SetCredentials(module, username, password string) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	opts, ok := p.modules[module]
+	if !ok {
+		return
+	}
+	opts.Username = username
+	opts.Password = password
+	p.modules[module] = opts
+
+	for key, sess := range p.sessions {
+		if key.module == module {
+			sess.SetCredentials(username, password)
+		}
+	}
+}
+
+// Close closes all cached sessions
+func (p *ProbeSessions) Close() error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	var err error
+	for key, sess := range p.sessions {
+		if cerr := sess.Close(); cerr != nil {
+			err = cerr
+		}
+		delete(p.sessions, key)
+	}
+	return err
+}
+
+func (p *ProbeSessions) closeIdle(now time.Time) {
+	for key, sess := range p.sessions {
+		if now.Sub(sess.lastUsed) < probeSessionIdleTimeout {
+			continue
+		}
+		if err := sess.Close(); err != nil {
+			log.Warn().Err(err).Str("target", key.target).Msg("failed to close idle probe session")
+		}
+		delete(p.sessions, key)
+	}
+}
+
+func (p *ProbeSessions) closeLeastRecentlyUsed() {
+	var oldestKey probeSessionKey
+	var oldest *probeSession
+	for key, sess := range p.sessions {
+		if oldest == nil || sess.lastUsed.Before(oldest.lastUsed) {
+			oldestKey, oldest = key, sess
+		}
+	}
+	if oldest == nil {
+		return
+	}
+	if err := oldest.Close(); err != nil {
+		log.Warn().Err(err).Str("target", oldestKey.target).Msg("failed to close probe session")
+	}
+	delete(p.sessions, oldestKey)
+}
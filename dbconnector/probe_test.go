@@ -0,0 +1,39 @@
+package dbconnector
+
+import (
+	"testing"
+)
+
+func TestProbeSessionsSetCredentials(t *testing.T) {
+	p := NewProbeSessions(map[string]ModuleOpts{
+		"default": {Username: "old", Password: "old"},
+		"other":   {Username: "other", Password: "other"},
+	})
+	defer p.Close()
+
+	cached, err := p.Connect("localhost:28015", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := p.Connect("localhost:28015", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.SetCredentials("default", "new", "secret")
+
+	if opts := cached.(*LazyRethinkSession).opts; opts.Username != "new" || opts.Password != "secret" {
+		t.Errorf("cached session credentials = %v/%v, want new/secret", opts.Username, opts.Password)
+	}
+	if opts := other.(*LazyRethinkSession).opts; opts.Username != "other" {
+		t.Errorf("session of other module username = %v, want other", opts.Username)
+	}
+
+	fresh, err := p.Connect("127.0.0.1:28015", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts := fresh.(*LazyRethinkSession).opts; opts.Username != "new" || opts.Password != "secret" {
+		t.Errorf("new session credentials = %v/%v, want new/secret", opts.Username, opts.Password)
+	}
+}
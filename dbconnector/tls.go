@@ -6,38 +6,246 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
-// PrepareTLSConfig creates tls.Config with certificate files
-func PrepareTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
-	config := new(tls.Config)
+// TLSOptions defines TLS parameters of the rethinkdb connection
+type TLSOptions struct {
+	// CAFile is a path to CA of the server certificates, system roots are used if it is empty
+	CAFile string
+	// CertFile is a path to the client certificate
+	CertFile string
+	// KeyFile is a path to the key of the client certificate
+	KeyFile string
+
+	// ServerName is verified in the server certificate instead of the address host
+	ServerName string
+	// InsecureSkipVerify disables verification of the server certificate, only for testing
+	InsecureSkipVerify bool
+	// MinVersion is the minimum TLS version: TLS10, TLS11, TLS12 or TLS13
+	MinVersion string
+	// MaxVersion is the maximum TLS version: TLS10, TLS11, TLS12 or TLS13
+	MaxVersion string
+	// CipherSuites are names of the enabled cipher suites for TLS 1.2 and lower, Go defaults if empty
+	CipherSuites []string
+}
+
+// versionTLS13 is tls.VersionTLS13 which is defined since Go 1.12
+const versionTLS13 = 0x0304
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": versionTLS13,
+}
+
+// cipherSuites maps names of the cipher suites for TLS 1.2 and lower to their ids
+var cipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_RC4_128_SHA":                tls.TLS_RSA_WITH_RC4_128_SHA,
+	"TLS_RSA_WITH_3DES_EDE_CBC_SHA":           tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_CBC_SHA256":         tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_RC4_128_SHA":        tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_RC4_128_SHA":          tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA,
+	"TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA":     tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":    tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":  tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+// PrepareTLSConfig validates TLS options and loads certificate files.
+// Returned reloader builds TLS config of every new session with the current CA,
+// the client certificate is reloaded on every handshake when the files are modified.
+func PrepareTLSConfig(opts TLSOptions) (*CertificateReloader, error) {
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, errors.New("cert file and key file must be both specified")
+	}
 
-	if len(certFile) != 0 || len(keyFile) != 0 {
-		if len(certFile) == 0 || len(keyFile) == 0 {
-			return nil, errors.New("cert file and key file must be both specified")
+	reloader := &CertificateReloader{
+		caFile:   opts.CAFile,
+		certFile: opts.CertFile,
+		keyFile:  opts.KeyFile,
+	}
+	err := reloader.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+
+	if opts.MinVersion != "" {
+		version, ok := tlsVersions[opts.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version '%v'", opts.MinVersion)
+		}
+		config.MinVersion = version
+	}
+	if opts.MaxVersion != "" {
+		version, ok := tlsVersions[opts.MaxVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version '%v'", opts.MaxVersion)
 		}
+		config.MaxVersion = version
+	}
 
-		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-		if err != nil {
-			return nil, fmt.Errorf("TLS file load error: %v", err)
+	if len(opts.CipherSuites) != 0 {
+		for _, name := range opts.CipherSuites {
+			id, ok := cipherSuites[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown cipher suite '%v'", name)
+			}
+			config.CipherSuites = append(config.CipherSuites, id)
 		}
+	}
 
-		config.Certificates = []tls.Certificate{cert}
+	if opts.CertFile != "" {
+		config.GetClientCertificate = reloader.getClientCertificate
 	}
+	reloader.config = config
+
+	return reloader, nil
+}
+
+// CertificateReloader keeps the client certificate and CA of the rethinkdb connection
+// and reloads them on the next handshake or session when the files are modified
+type CertificateReloader struct {
+	caFile   string
+	certFile string
+	keyFile  string
+	// config is the TLS config without the CA
+	config *tls.Config
+
+	m      sync.Mutex
+	cert   *tls.Certificate
+	leaf   *x509.Certificate
+	pool   *x509.CertPool
+	stamps map[string]fileStamp
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
 
-	if len(caFile) != 0 {
-		ca, err := ioutil.ReadFile(caFile)
+func statFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
+
+// reload loads the files and replaces the current certificate and CA if they are valid
+func (c *CertificateReloader) reload() error {
+	stamps := make(map[string]fileStamp)
+	var cert *tls.Certificate
+	var leaf *x509.Certificate
+	if c.certFile != "" {
+		stamps[c.certFile] = statFile(c.certFile)
+		stamps[c.keyFile] = statFile(c.keyFile)
+
+		pair, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
 		if err != nil {
-			return nil, fmt.Errorf("TLS CA file load error: %v", err)
+			return fmt.Errorf("TLS file load error: %v", err)
 		}
+		leaf, err = x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("TLS certificate parse error: %v", err)
+		}
+		cert = &pair
+	}
+
+	var pool *x509.CertPool
+	if c.caFile != "" {
+		stamps[c.caFile] = statFile(c.caFile)
+
+		ca, err := ioutil.ReadFile(c.caFile)
+		if err != nil {
+			return fmt.Errorf("TLS CA file load error: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("TLS credentials: failed to append ca")
+		}
+	}
 
-		cp := x509.NewCertPool()
-		if !cp.AppendCertsFromPEM(ca) {
-			return nil, fmt.Errorf("TLS credentials: failed to append ca")
+	c.cert = cert
+	c.leaf = leaf
+	c.pool = pool
+	c.stamps = stamps
+	return nil
+}
+
+// current returns the certificate and CA reloaded if the files are modified.
+// Invalid files are logged and the previous certificate and CA are kept.
+func (c *CertificateReloader) current() (*tls.Certificate, *x509.CertPool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for path, stamp := range c.stamps {
+		if statFile(path) == stamp {
+			continue
 		}
+		err := c.reload()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to reload rethinkdb TLS certificates, keeping the previous ones")
+			// to not retry until the files are modified again
+			for path := range c.stamps {
+				c.stamps[path] = statFile(path)
+			}
+		} else {
+			log.Info().Msg("rethinkdb TLS certificates reloaded")
+		}
+		break
+	}
+	return c.cert, c.pool
+}
 
-		config.RootCAs = cp
+// NotAfter returns expiration time of the current client certificate, false if there is no certificate
+func (c *CertificateReloader) NotAfter() (time.Time, bool) {
+	c.current()
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.leaf == nil {
+		return time.Time{}, false
 	}
+	return c.leaf.NotAfter, true
+}
 
-	return config, nil
+func (c *CertificateReloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cert, _ := c.current()
+	return cert, nil
+}
+
+// TLSConfig returns TLS config of a new session with the current CA.
+// The server certificate is verified with the server name or the dialed host or IP by the standard verification.
+func (c *CertificateReloader) TLSConfig() *tls.Config {
+	_, pool := c.current()
+	config := c.config.Clone()
+	if c.caFile != "" {
+		config.RootCAs = pool
+	}
+	return config
 }
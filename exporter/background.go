@@ -0,0 +1,83 @@
+package exporter
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// snapshot is a result of the background collecting
+type snapshot struct {
+	metrics  []prometheus.Metric
+	time     time.Time
+	duration time.Duration
+}
+
+// CollectInBackground collects stats every interval until ctx is done.
+// After the first collecting scrapes are served from the last snapshot instead of querying rethinkdb.
+func (e *RethinkdbExporter) CollectInBackground(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		e.collectSnapshot(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *RethinkdbExporter) collectSnapshot(ctx context.Context) {
+	ctx, cancel := withScrapeTimeout(ctx, e.opts.ScrapeTimeout)
+	defer cancel()
+
+	start := time.Now()
+
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	var metrics []prometheus.Metric
+	go func() {
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		close(done)
+	}()
+	e.collect(ctx, e.rconn, true, ch)
+	close(ch)
+	<-done
+
+	snap := &snapshot{
+		metrics:  metrics,
+		time:     start,
+		duration: time.Since(start),
+	}
+
+	e.snapshotMu.Lock()
+	e.snapshot = snap
+	e.snapshotMu.Unlock()
+
+	log.Debug().Dur("duration", snap.duration).Int("metrics", len(metrics)).Msg("background collect finished")
+}
+
+// sendSnapshot sends metrics of the last snapshot, returns false if there is no snapshot yet
+func (e *RethinkdbExporter) sendSnapshot(ch chan<- prometheus.Metric) bool {
+	e.snapshotMu.RLock()
+	snap := e.snapshot
+	e.snapshotMu.RUnlock()
+
+	if snap == nil {
+		return false
+	}
+
+	for _, m := range snap.metrics {
+		ch <- m
+	}
+	ch <- prometheus.MustNewConstMetric(e.metrics.snapshotAgeSeconds, prometheus.GaugeValue, time.Since(snap.time).Seconds())
+	ch <- prometheus.MustNewConstMetric(e.metrics.snapshotDurationSeconds, prometheus.GaugeValue, snap.duration.Seconds())
+	return true
+}
@@ -0,0 +1,110 @@
+package exporter
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+func init() {
+	registerCollector("cluster_config", false, (*RethinkdbExporter).collectClusterConfig)
+}
+
+type tableConfig struct {
+	Database   string `rethinkdb:"db"`
+	Table      string `rethinkdb:"name"`
+	PrimaryKey string `rethinkdb:"primary_key"`
+	Durability string `rethinkdb:"durability"`
+	WriteAcks  string `rethinkdb:"write_acks"`
+	Shards     []struct {
+		PrimaryReplica    string   `rethinkdb:"primary_replica"`
+		Replicas          []string `rethinkdb:"replicas"`
+		NonvotingReplicas []string `rethinkdb:"nonvoting_replicas"`
+	} `rethinkdb:"shards"`
+}
+
+type dbConfig struct {
+	Name string `rethinkdb:"name"`
+}
+
+type serverConfig struct {
+	ID          string      `rethinkdb:"id"`
+	Name        string      `rethinkdb:"name"`
+	Tags        []string    `rethinkdb:"tags"`
+	CacheSizeMB interface{} `rethinkdb:"cache_size_mb"` // "auto" or number of megabytes
+}
+
+// collectClusterConfig exports configuration of tables, databases and servers
+// from table_config, db_config and server_config system tables
+func (e *RethinkdbExporter) collectClusterConfig(ctx context.Context, rconn r.QueryExecutor, ch chan<- prometheus.Metric) int {
+	errcount := 0
+
+	var tables []tableConfig
+	err := r.DB(r.SystemDatabase).Table(r.TableConfigSystemTable).ReadAll(&tables, rconn, r.RunOpts{Context: ctx})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to query table config table")
+		errcount++
+	}
+	for _, table := range tables {
+		if e.tableAllowed(table.Database, table.Table) {
+			e.processTableConfig(table, ch)
+		}
+	}
+
+	var dbs []dbConfig
+	err = r.DB(r.SystemDatabase).Table(r.DBConfigSystemTable).ReadAll(&dbs, rconn, r.RunOpts{Context: ctx})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to query db config table")
+		errcount++
+	}
+	for _, db := range dbs {
+		if !e.opts.DBFilter.Match(db.Name) {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(e.metrics.dbConfigInfo, prometheus.GaugeValue, 1, db.Name)
+	}
+
+	var servers []serverConfig
+	err = r.DB(r.SystemDatabase).Table(r.ServerConfigSystemTable).ReadAll(&servers, rconn, r.RunOpts{Context: ctx})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to query server config table")
+		errcount++
+	}
+	for _, server := range servers {
+		if e.serverAllowed(server.Name) {
+			e.processServerConfig(server, ch)
+		}
+	}
+
+	return errcount
+}
+
+func (e *RethinkdbExporter) processTableConfig(table tableConfig, ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(e.metrics.tableConfigInfo, prometheus.GaugeValue, 1, table.Database, table.Table, table.PrimaryKey, table.Durability, table.WriteAcks)
+	ch <- prometheus.MustNewConstMetric(e.metrics.tableConfigShards, prometheus.GaugeValue, float64(len(table.Shards)), table.Database, table.Table)
+
+	for i, shard := range table.Shards {
+		shardID := strconv.Itoa(i)
+
+		ch <- prometheus.MustNewConstMetric(e.metrics.tableShardConfigReplicas, prometheus.GaugeValue, float64(len(shard.Replicas)), table.Database, table.Table, shardID, shard.PrimaryReplica)
+		ch <- prometheus.MustNewConstMetric(e.metrics.tableShardConfigNonvotingReplicas, prometheus.GaugeValue, float64(len(shard.NonvotingReplicas)), table.Database, table.Table, shardID)
+	}
+}
+
+func (e *RethinkdbExporter) processServerConfig(server serverConfig, ch chan<- prometheus.Metric) {
+	tags := append([]string(nil), server.Tags...)
+	sort.Strings(tags)
+
+	cacheSize := "auto"
+	if mb, ok := server.CacheSizeMB.(float64); ok {
+		cacheSize = strconv.FormatFloat(mb, 'f', -1, 64)
+		ch <- prometheus.MustNewConstMetric(e.metrics.serverConfigCacheSizeBytes, prometheus.GaugeValue, mb*bytesInMegabyte, server.Name)
+	}
+
+	ch <- prometheus.MustNewConstMetric(e.metrics.serverConfigInfo, prometheus.GaugeValue, 1, server.Name, strings.Join(tags, ","), cacheSize)
+}
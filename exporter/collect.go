@@ -4,36 +4,163 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/sync/errgroup"
 	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
 )
 
-// Collect send collected metrics values to the prometheus chan
+// Collect send collected metrics values to the prometheus chan.
+// If stats are collected in background, the last snapshot is sent.
 func (e *RethinkdbExporter) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := withScrapeTimeout(context.Background(), e.opts.ScrapeTimeout)
+	defer cancel()
+
+	e.collectMain(ctx, ch)
+}
+
+// collectMain sends metrics of the exporter connection: reload status, client certificate expiry, session health, latency probe and the last snapshot or stats collected until ctx is done
+func (e *RethinkdbExporter) collectMain(ctx context.Context, ch chan<- prometheus.Metric) {
+	e.sendReloadStatus(ch)
+	e.sendClientCertificateExpiry(ch)
+	e.sendLatencyProbe(ch)
+	if !e.sendSnapshot(ch) {
+		e.collect(ctx, e.rconn, true, ch)
+	}
+	// session health includes results of the queries of this scrape
+	e.sendSessionHealth(e.rconn, ch)
+}
+
+// names of the core collectors in collector_* metrics, they are not in the registry:
+// stats defines if the cluster is up and table_info is enabled by the table docs estimates option
+const (
+	statsCollector     = "stats"
+	tableInfoCollector = "table_info"
+)
+
+func init() {
+	registerCollector("table_status", true, (*RethinkdbExporter).collectTableStatus)
+}
+
+// collect sends metrics collected until ctx is done.
+// Metrics collected before the timeout are still sent. Custom queries run only if they are enabled for the connection.
+func (e *RethinkdbExporter) collect(ctx context.Context, rconn r.QueryExecutor, customQueries bool, ch chan<- prometheus.Metric) {
 	start := time.Now()
 
-	ctx := context.TODO() // TODO: add scrape timeout
-	errcount := e.collectRethinkStats(ctx, ch)
+	up, errcount := e.collectRethinkStats(ctx, rconn, ch)
+	ch <- prometheus.MustNewConstMetric(e.metrics.up, prometheus.GaugeValue, boolToFloat(up))
+	if rconn == e.rconn {
+		e.recordCollect(up)
+	}
+
+	// other system tables are not queried if the cluster is unreachable
+	if up {
+		for _, c := range e.collectors {
+			if c.Name() == customQueriesCollector && !customQueries {
+				continue
+			}
+			errcount += e.runCollector(ctx, rconn, c, ch)
+		}
+	}
+
+	timedOut := 0.0
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Warn().Msg("scrape timed out, sending partial stats")
+		timedOut = 1
+	}
 
 	elapsed := time.Since(start)
 	ch <- prometheus.MustNewConstMetric(e.metrics.scrapeErrors, prometheus.GaugeValue, float64(errcount))
 	ch <- prometheus.MustNewConstMetric(e.metrics.scrapeLatency, prometheus.GaugeValue, elapsed.Seconds())
+	ch <- prometheus.MustNewConstMetric(e.metrics.scrapeTimeout, prometheus.GaugeValue, timedOut)
 
 	log.Debug().Dur("duration", elapsed).Msg("collect finished")
 }
 
-func (e *RethinkdbExporter) collectRethinkStats(ctx context.Context, ch chan<- prometheus.Metric) int {
+// sendClientCertificateExpiry sends expiration time of the client certificate if there is one
+func (e *RethinkdbExporter) sendClientCertificateExpiry(ch chan<- prometheus.Metric) {
+	if e.opts.ClientCertificate == nil {
+		return
+	}
+	notAfter, ok := e.opts.ClientCertificate.NotAfter()
+	if !ok {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(e.metrics.clientCertificateExpiryTimestamp, prometheus.GaugeValue, float64(notAfter.Unix()))
+}
+
+// breakerStates are states of the circuit breaker of the rethinkdb connection
+var breakerStates = []string{"closed", "open", "half_open"}
+
+// sendSessionHealth sends circuit breaker state and reconnect counters if the connection exports them.
+// They are sent on every scrape after its queries, not with the background snapshot.
+func (e *RethinkdbExporter) sendSessionHealth(rconn r.QueryExecutor, ch chan<- prometheus.Metric) {
+	health, ok := rconn.(SessionHealth)
+	if !ok {
+		return
+	}
+	current := health.BreakerState()
+	for _, state := range breakerStates {
+		ch <- prometheus.MustNewConstMetric(e.metrics.sessionCircuitBreakerState, prometheus.GaugeValue, boolToFloat(state == current), state)
+	}
+	ch <- prometheus.MustNewConstMetric(e.metrics.sessionReconnectsTotal, prometheus.CounterValue, float64(health.Reconnects()))
+	ch <- prometheus.MustNewConstMetric(e.metrics.sessionConnectFailuresTotal, prometheus.CounterValue, float64(health.ConnectFailures()))
+}
+
+// queryErrorLevel returns debug level instead of the level while the circuit breaker of the connection is not closed:
+// the breaker logs when it opens and queries fail fast on every scrape until it is closed
+func queryErrorLevel(rconn r.QueryExecutor, level zerolog.Level) zerolog.Level {
+	health, ok := rconn.(SessionHealth)
+	if ok && health.BreakerState() != "closed" {
+		return zerolog.DebugLevel
+	}
+	return level
+}
+
+// withScrapeTimeout returns context limited by timeout, zero timeout means no limit
+func withScrapeTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// collectRethinkStats collects stats table and table info estimates, these collectors run concurrently.
+// Cluster is considered up if the stats table is queried successfully.
+func (e *RethinkdbExporter) collectRethinkStats(ctx context.Context, rconn r.QueryExecutor, ch chan<- prometheus.Metric) (bool, int) {
+	start := time.Now()
+
+	wg := &errgroup.Group{}
+	up, errcount := e.readStats(ctx, rconn, wg, ch)
+	e.sendCollectorResult(ch, statsCollector, start, errcount)
+
+	infoErrcount := 0
+	err := wg.Wait()
+	if err != nil {
+		log.Warn().Err(err).Msg("error while processing stat")
+		infoErrcount++
+	}
+	if e.metrics.tableRowsCount != nil && up {
+		e.sendCollectorResult(ch, tableInfoCollector, start, infoErrcount)
+	}
+	e.tableInfo.expire(time.Now().Add(-tableInfoExpiration - e.opts.TableInfoRefreshInterval))
+
+	return up, errcount + infoErrcount
+}
+
+// readStats processes rows of stats table, table info lookups are started in the wg
+func (e *RethinkdbExporter) readStats(ctx context.Context, rconn r.QueryExecutor, wg *errgroup.Group, ch chan<- prometheus.Metric) (bool, int) {
 	errcount := 0
 
-	cur, err := r.DB(r.SystemDatabase).Table(r.StatsSystemTable).Run(e.rconn, r.RunOpts{Context: ctx})
+	cur, err := r.DB(r.SystemDatabase).Table(r.StatsSystemTable).Run(rconn, r.RunOpts{Context: ctx})
 	if err != nil {
-		log.Error().Err(err).Msg("failed to query system stats table")
+		log.WithLevel(queryErrorLevel(rconn, zerolog.ErrorLevel)).Err(err).Msg("failed to query system stats table")
 		errcount++
-		return errcount
+		return false, errcount
 	}
 	defer func() {
 		err := cur.Close()
@@ -45,31 +172,29 @@ func (e *RethinkdbExporter) collectRethinkStats(ctx context.Context, ch chan<- p
 	if cur.Err() != nil {
 		log.Error().Err(cur.Err()).Msg("query error from cursor")
 		errcount++
-		return errcount
+		return true, errcount
 	}
 
-	wg := &errgroup.Group{}
 	var stat stat
 	for cur.Next(&stat) {
 		if cur.Err() != nil {
 			log.Error().Err(cur.Err()).Msg("query error from cursor")
 			errcount++
-			return errcount
+			return true, errcount
 		}
 
-		err = e.processStat(ctx, stat, wg, ch)
+		err = e.processStat(ctx, rconn, stat, wg, ch)
 		if err != nil {
 			log.Warn().Err(err).Msg("error while processing stat")
 			errcount++
 		}
 	}
-	err = wg.Wait()
-	if err != nil {
-		log.Warn().Err(err).Msg("error while processing stat")
+	if cur.Err() != nil {
+		log.Error().Err(cur.Err()).Msg("query error from cursor")
 		errcount++
 	}
 
-	return errcount
+	return true, errcount
 }
 
 type stat struct {
@@ -83,9 +208,13 @@ type stat struct {
 
 type queryEngine struct {
 	ClientConnections float64 `rethinkdb:"client_connections"`
+	ClientsActive     float64 `rethinkdb:"clients_active"`
 	QPS               float64 `rethinkdb:"queries_per_sec"`
+	QueriesTotal      float64 `rethinkdb:"queries_total"`
 	ReadDocsPerSec    float64 `rethinkdb:"read_docs_per_sec"`
+	ReadDocsTotal     float64 `rethinkdb:"read_docs_total"`
 	WrittenDocsPerSec float64 `rethinkdb:"written_docs_per_sec"`
+	WrittenDocsTotal  float64 `rethinkdb:"written_docs_total"`
 }
 
 type storageEngine struct {
@@ -94,18 +223,37 @@ type storageEngine struct {
 	} `rethinkdb:"cache"`
 	Disk struct {
 		ReadBytesPerSec    float64 `rethinkdb:"read_bytes_per_sec"`
+		ReadBytesTotal     float64 `rethinkdb:"read_bytes_total"`
 		WrittenBytesPerSec float64 `rethinkdb:"written_bytes_per_sec"`
+		WrittenBytesTotal  float64 `rethinkdb:"written_bytes_total"`
 		SpaceUsage         struct {
-			DataBytes float64 `rethinkdb:"data_bytes"`
+			DataBytes         float64 `rethinkdb:"data_bytes"`
+			GarbageBytes      float64 `rethinkdb:"garbage_bytes"`
+			MetadataBytes     float64 `rethinkdb:"metadata_bytes"`
+			PreallocatedBytes float64 `rethinkdb:"preallocated_bytes"`
 		} `rethinkdb:"space_usage"`
 	} `rethinkdb:"disk"`
 }
 
-type info struct {
-	DocCountEstimates []float64 `rethinkdb:"doc_count_estimates"`
+type tableStatus struct {
+	Database string `rethinkdb:"db"`
+	Table    string `rethinkdb:"name"`
+	Status   struct {
+		AllReplicasReady      bool `rethinkdb:"all_replicas_ready"`
+		ReadyForOutdatedReads bool `rethinkdb:"ready_for_outdated_reads"`
+		ReadyForReads         bool `rethinkdb:"ready_for_reads"`
+		ReadyForWrites        bool `rethinkdb:"ready_for_writes"`
+	} `rethinkdb:"status"`
+	Shards []struct {
+		PrimaryReplicas []string `rethinkdb:"primary_replicas"`
+		Replicas        []struct {
+			Server string `rethinkdb:"server"`
+			State  string `rethinkdb:"state"`
+		} `rethinkdb:"replicas"`
+	} `rethinkdb:"shards"`
 }
 
-func (e *RethinkdbExporter) processStat(ctx context.Context, stat stat, wg *errgroup.Group, ch chan<- prometheus.Metric) error {
+func (e *RethinkdbExporter) processStat(ctx context.Context, rconn r.QueryExecutor, stat stat, wg *errgroup.Group, ch chan<- prometheus.Metric) error {
 	if len(stat.ID) == 0 {
 		return errors.New("unexpected empty stat id")
 	}
@@ -113,11 +261,17 @@ func (e *RethinkdbExporter) processStat(ctx context.Context, stat stat, wg *errg
 	case "cluster":
 		e.processClusterStat(stat, ch)
 	case "server":
-		e.processServerStat(stat, ch)
+		if e.serverAllowed(stat.Server) {
+			e.processServerStat(stat, ch)
+		}
 	case "table":
-		e.processTableStat(ctx, stat, wg, ch)
+		if e.tableAllowed(stat.Database, stat.Table) {
+			e.processTableStat(ctx, rconn, stat, wg, ch)
+		}
 	case "table_server":
-		e.processTableServerStat(stat, ch)
+		if e.tableAllowed(stat.Database, stat.Table) && e.serverAllowed(stat.Server) {
+			e.processTableServerStat(stat, ch)
+		}
 	default:
 		return fmt.Errorf("unexpected stat id: '%v'", stat.ID[0])
 	}
@@ -126,6 +280,8 @@ func (e *RethinkdbExporter) processStat(ctx context.Context, stat stat, wg *errg
 
 func (e *RethinkdbExporter) processClusterStat(stat stat, ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(e.metrics.clusterClientConnections, prometheus.GaugeValue, stat.QueryEngine.ClientConnections)
+	ch <- prometheus.MustNewConstMetric(e.metrics.clusterClientsActive, prometheus.GaugeValue, stat.QueryEngine.ClientsActive)
+	ch <- prometheus.MustNewConstMetric(e.metrics.clusterQueriesPerSecond, prometheus.GaugeValue, stat.QueryEngine.QPS)
 
 	ch <- prometheus.MustNewConstMetric(e.metrics.clusterDocsPerSecond, prometheus.GaugeValue, stat.QueryEngine.ReadDocsPerSec, readOperation)
 	ch <- prometheus.MustNewConstMetric(e.metrics.clusterDocsPerSecond, prometheus.GaugeValue, stat.QueryEngine.WrittenDocsPerSec, writtenOperation)
@@ -133,37 +289,60 @@ func (e *RethinkdbExporter) processClusterStat(stat stat, ch chan<- prometheus.M
 
 func (e *RethinkdbExporter) processServerStat(stat stat, ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(e.metrics.serverClientConnections, prometheus.GaugeValue, stat.QueryEngine.ClientConnections, stat.Server)
+	ch <- prometheus.MustNewConstMetric(e.metrics.serverClientsActive, prometheus.GaugeValue, stat.QueryEngine.ClientsActive, stat.Server)
 
 	ch <- prometheus.MustNewConstMetric(e.metrics.serverDocsPerSecond, prometheus.GaugeValue, stat.QueryEngine.ReadDocsPerSec, stat.Server, readOperation)
 	ch <- prometheus.MustNewConstMetric(e.metrics.serverDocsPerSecond, prometheus.GaugeValue, stat.QueryEngine.WrittenDocsPerSec, stat.Server, writtenOperation)
 
-	ch <- prometheus.MustNewConstMetric(e.metrics.serverQueriesPerSecond, prometheus.GaugeValue, stat.QueryEngine.ReadDocsPerSec, stat.Server)
+	ch <- prometheus.MustNewConstMetric(e.metrics.serverDocsTotal, prometheus.CounterValue, stat.QueryEngine.ReadDocsTotal, stat.Server, readOperation)
+	ch <- prometheus.MustNewConstMetric(e.metrics.serverDocsTotal, prometheus.CounterValue, stat.QueryEngine.WrittenDocsTotal, stat.Server, writtenOperation)
+
+	ch <- prometheus.MustNewConstMetric(e.metrics.serverQueriesPerSecond, prometheus.GaugeValue, stat.QueryEngine.QPS, stat.Server)
+	ch <- prometheus.MustNewConstMetric(e.metrics.serverQueriesTotal, prometheus.CounterValue, stat.QueryEngine.QueriesTotal, stat.Server)
 }
 
-func (e *RethinkdbExporter) processTableStat(ctx context.Context, stat stat, wg *errgroup.Group, ch chan<- prometheus.Metric) {
+func (e *RethinkdbExporter) processTableStat(ctx context.Context, rconn r.QueryExecutor, stat stat, wg *errgroup.Group, ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(e.metrics.tableDocsPerSecond, prometheus.GaugeValue, stat.QueryEngine.ReadDocsPerSec, stat.Database, stat.Table, readOperation)
 	ch <- prometheus.MustNewConstMetric(e.metrics.tableDocsPerSecond, prometheus.GaugeValue, stat.QueryEngine.WrittenDocsPerSec, stat.Database, stat.Table, writtenOperation)
 
 	if e.metrics.tableRowsCount != nil {
-		dbName := stat.Database
-		tableName := stat.Table
-
-		wg.Go(func() error {
-			var info info
-			err := r.DB(dbName).Table(tableName).Info().ReadOne(&info, e.rconn, r.RunOpts{Context: ctx})
-			if err != nil {
-				log.Warn().Err(err).Str("db", dbName).Str("table", tableName).Msg("failed to get table info")
-				return err
-			}
+		e.processTableInfo(ctx, rconn, stat.Database, stat.Table, wg, ch)
+	}
+}
 
-			sum := 0.0
-			for _, e := range info.DocCountEstimates {
-				sum += float64(e)
-			}
+// collectTableStatus exports availability of the tables and readiness of their shards from table_status system table
+func (e *RethinkdbExporter) collectTableStatus(ctx context.Context, rconn r.QueryExecutor, ch chan<- prometheus.Metric) int {
+	var statuses []tableStatus
+	err := r.DB(r.SystemDatabase).Table(r.TableStatusSystemTable).ReadAll(&statuses, rconn, r.RunOpts{Context: ctx})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to query table status table")
+		return 1
+	}
 
-			ch <- prometheus.MustNewConstMetric(e.metrics.tableRowsCount, prometheus.GaugeValue, sum, dbName, tableName)
-			return nil
-		})
+	for _, status := range statuses {
+		if e.tableAllowed(status.Database, status.Table) {
+			e.processTableStatus(status, ch)
+		}
+	}
+	return 0
+}
+
+func (e *RethinkdbExporter) processTableStatus(status tableStatus, ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(e.metrics.tableReadyForOutdatedReads, prometheus.GaugeValue, boolToFloat(status.Status.ReadyForOutdatedReads), status.Database, status.Table)
+	ch <- prometheus.MustNewConstMetric(e.metrics.tableReadyForReads, prometheus.GaugeValue, boolToFloat(status.Status.ReadyForReads), status.Database, status.Table)
+	ch <- prometheus.MustNewConstMetric(e.metrics.tableReadyForWrites, prometheus.GaugeValue, boolToFloat(status.Status.ReadyForWrites), status.Database, status.Table)
+	ch <- prometheus.MustNewConstMetric(e.metrics.tableAllReplicasReady, prometheus.GaugeValue, boolToFloat(status.Status.AllReplicasReady), status.Database, status.Table)
+
+	for i, shard := range status.Shards {
+		shardID := strconv.Itoa(i)
+
+		ch <- prometheus.MustNewConstMetric(e.metrics.tableShardPrimaryReplicas, prometheus.GaugeValue, float64(len(shard.PrimaryReplicas)), status.Database, status.Table, shardID)
+		for _, replica := range shard.Replicas {
+			if !e.serverAllowed(replica.Server) {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(e.metrics.tableShardReplicaState, prometheus.GaugeValue, 1, status.Database, status.Table, shardID, replica.Server, replica.State)
+		}
 	}
 }
 
@@ -171,10 +350,26 @@ func (e *RethinkdbExporter) processTableServerStat(stat stat, ch chan<- promethe
 	ch <- prometheus.MustNewConstMetric(e.metrics.tableReplicaDocsPerSecond, prometheus.GaugeValue, stat.QueryEngine.ReadDocsPerSec, stat.Database, stat.Table, stat.Server, readOperation)
 	ch <- prometheus.MustNewConstMetric(e.metrics.tableReplicaDocsPerSecond, prometheus.GaugeValue, stat.QueryEngine.WrittenDocsPerSec, stat.Database, stat.Table, stat.Server, writtenOperation)
 
+	ch <- prometheus.MustNewConstMetric(e.metrics.tableReplicaDocsTotal, prometheus.CounterValue, stat.QueryEngine.ReadDocsTotal, stat.Database, stat.Table, stat.Server, readOperation)
+	ch <- prometheus.MustNewConstMetric(e.metrics.tableReplicaDocsTotal, prometheus.CounterValue, stat.QueryEngine.WrittenDocsTotal, stat.Database, stat.Table, stat.Server, writtenOperation)
+
 	ch <- prometheus.MustNewConstMetric(e.metrics.tableReplicaCacheBytes, prometheus.GaugeValue, stat.StorageEngine.Cache.InUseBytes, stat.Database, stat.Table, stat.Server)
 
 	ch <- prometheus.MustNewConstMetric(e.metrics.tableReplicaIO, prometheus.GaugeValue, stat.StorageEngine.Disk.ReadBytesPerSec, stat.Database, stat.Table, stat.Server, readOperation)
 	ch <- prometheus.MustNewConstMetric(e.metrics.tableReplicaIO, prometheus.GaugeValue, stat.StorageEngine.Disk.WrittenBytesPerSec, stat.Database, stat.Table, stat.Server, writtenOperation)
 
+	ch <- prometheus.MustNewConstMetric(e.metrics.tableReplicaIOTotal, prometheus.CounterValue, stat.StorageEngine.Disk.ReadBytesTotal, stat.Database, stat.Table, stat.Server, readOperation)
+	ch <- prometheus.MustNewConstMetric(e.metrics.tableReplicaIOTotal, prometheus.CounterValue, stat.StorageEngine.Disk.WrittenBytesTotal, stat.Database, stat.Table, stat.Server, writtenOperation)
+
 	ch <- prometheus.MustNewConstMetric(e.metrics.tableReplicaDataBytes, prometheus.GaugeValue, stat.StorageEngine.Disk.SpaceUsage.DataBytes, stat.Database, stat.Table, stat.Server)
+	ch <- prometheus.MustNewConstMetric(e.metrics.tableReplicaGarbageBytes, prometheus.GaugeValue, stat.StorageEngine.Disk.SpaceUsage.GarbageBytes, stat.Database, stat.Table, stat.Server)
+	ch <- prometheus.MustNewConstMetric(e.metrics.tableReplicaMetadataBytes, prometheus.GaugeValue, stat.StorageEngine.Disk.SpaceUsage.MetadataBytes, stat.Database, stat.Table, stat.Server)
+	ch <- prometheus.MustNewConstMetric(e.metrics.tableReplicaPreallocatedBytes, prometheus.GaugeValue, stat.StorageEngine.Disk.SpaceUsage.PreallocatedBytes, stat.Database, stat.Table, stat.Server)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
 }
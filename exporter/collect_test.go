@@ -0,0 +1,38 @@
+package exporter
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+// healthConn is the connection exporting the circuit breaker state
+type healthConn struct {
+	r.QueryExecutor
+	state string
+}
+
+func (c *healthConn) BreakerState() string    { return c.state }
+func (c *healthConn) Reconnects() uint64      { return 0 }
+func (c *healthConn) ConnectFailures() uint64 { return 0 }
+
+func TestQueryErrorLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		rconn r.QueryExecutor
+		want  zerolog.Level
+	}{
+		{name: "no session health", rconn: r.NewMock(), want: zerolog.ErrorLevel},
+		{name: "closed", rconn: &healthConn{state: "closed"}, want: zerolog.ErrorLevel},
+		{name: "open", rconn: &healthConn{state: "open"}, want: zerolog.DebugLevel},
+		{name: "half open", rconn: &healthConn{state: "half_open"}, want: zerolog.DebugLevel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := queryErrorLevel(tt.rconn, zerolog.ErrorLevel); got != tt.want {
+				t.Errorf("queryErrorLevel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
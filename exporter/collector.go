@@ -0,0 +1,122 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+// collector collects metrics of a rethinkdb system table.
+// Collectors are registered in init of their files and can be switched on or off independently.
+type collector interface {
+	// Name identifies the collector in flags and collector_* metrics
+	Name() string
+	// EnabledByDefault tells if the collector runs unless it is disabled explicitly
+	EnabledByDefault() bool
+	// Collect sends metrics to the chan and returns number of errors
+	Collect(ctx context.Context, rconn r.QueryExecutor, ch chan<- prometheus.Metric) int
+}
+
+// collectMethod is a method of the exporter collecting a system table
+type collectMethod func(e *RethinkdbExporter, ctx context.Context, rconn r.QueryExecutor, ch chan<- prometheus.Metric) int
+
+type collectorRegistration struct {
+	enabledByDefault bool
+	collect          collectMethod
+}
+
+var collectorRegistry = make(map[string]collectorRegistration)
+
+// registerCollector adds collector to the registry, it must be called from init
+func registerCollector(name string, enabledByDefault bool, collect collectMethod) {
+	if _, ok := collectorRegistry[name]; ok {
+		panic(fmt.Sprintf("collector '%v' is already registered", name))
+	}
+	collectorRegistry[name] = collectorRegistration{
+		enabledByDefault: enabledByDefault,
+		collect:          collect,
+	}
+}
+
+// Collectors returns names of the available collectors and whether they are enabled by default
+func Collectors() map[string]bool {
+	res := make(map[string]bool, len(collectorRegistry))
+	for name, reg := range collectorRegistry {
+		res[name] = reg.enabledByDefault
+	}
+	return res
+}
+
+// methodCollector is a collector implemented by the exporter method
+type methodCollector struct {
+	e                *RethinkdbExporter
+	name             string
+	enabledByDefault bool
+	collect          collectMethod
+}
+
+func (c *methodCollector) Name() string {
+	return c.name
+}
+
+func (c *methodCollector) EnabledByDefault() bool {
+	return c.enabledByDefault
+}
+
+func (c *methodCollector) Collect(ctx context.Context, rconn r.QueryExecutor, ch chan<- prometheus.Metric) int {
+	return c.collect(c.e, ctx, rconn, ch)
+}
+
+// initCollectors makes enabled collectors sorted by name.
+// Collectors missing in the options are enabled by default flag.
+func (e *RethinkdbExporter) initCollectors() error {
+	for name := range e.opts.Collectors {
+		if _, ok := collectorRegistry[name]; !ok {
+			return fmt.Errorf("unknown collector '%v'", name)
+		}
+	}
+
+	names := make([]string, 0, len(collectorRegistry))
+	for name := range collectorRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	e.collectors = nil
+	for _, name := range names {
+		reg := collectorRegistry[name]
+		enabled, ok := e.opts.Collectors[name]
+		if !ok {
+			enabled = reg.enabledByDefault
+		}
+		if !enabled {
+			continue
+		}
+		log.Debug().Str("collector", name).Msg("enabled collector")
+		e.collectors = append(e.collectors, &methodCollector{
+			e:                e,
+			name:             name,
+			enabledByDefault: reg.enabledByDefault,
+			collect:          reg.collect,
+		})
+	}
+	return nil
+}
+
+// runCollector runs the collector and sends its duration and success
+func (e *RethinkdbExporter) runCollector(ctx context.Context, rconn r.QueryExecutor, c collector, ch chan<- prometheus.Metric) int {
+	start := time.Now()
+	errcount := c.Collect(ctx, rconn, ch)
+	e.sendCollectorResult(ch, c.Name(), start, errcount)
+	return errcount
+}
+
+func (e *RethinkdbExporter) sendCollectorResult(ch chan<- prometheus.Metric, name string, start time.Time, errcount int) {
+	ch <- prometheus.MustNewConstMetric(e.metrics.collectorDurationSeconds, prometheus.GaugeValue, time.Since(start).Seconds(), name)
+	ch <- prometheus.MustNewConstMetric(e.metrics.collectorSuccess, prometheus.GaugeValue, boolToFloat(errcount == 0), name)
+}
@@ -0,0 +1,80 @@
+package exporter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+func noopCollect(*RethinkdbExporter, context.Context, r.QueryExecutor, chan<- prometheus.Metric) int {
+	return 0
+}
+
+func TestRegisterCollectorDuplicate(t *testing.T) {
+	const name = "test_duplicate"
+	registerCollector(name, false, noopCollect)
+	defer delete(collectorRegistry, name)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on duplicate collector registration")
+		}
+	}()
+	registerCollector(name, true, noopCollect)
+}
+
+func TestInitCollectors(t *testing.T) {
+	tests := []struct {
+		name       string
+		collectors map[string]bool
+		enabled    map[string]bool
+		wantErr    bool
+	}{
+		{
+			name:    "defaults",
+			enabled: map[string]bool{"server_status": true, "table_status": true, "cluster_config": false, "nodes": false},
+		},
+		{
+			name:       "enable and disable",
+			collectors: map[string]bool{"cluster_config": true, "jobs": false},
+			enabled:    map[string]bool{"cluster_config": true, "jobs": false, "current_issues": true},
+		},
+		{
+			name:       "unknown collector",
+			collectors: map[string]bool{"unknown": true},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &RethinkdbExporter{opts: Options{Collectors: tt.collectors}}
+			err := e.initCollectors()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := make(map[string]bool)
+			prev := ""
+			for _, c := range e.collectors {
+				if c.Name() <= prev {
+					t.Errorf("collectors are not sorted: %v after %v", c.Name(), prev)
+				}
+				prev = c.Name()
+				got[c.Name()] = true
+			}
+			for name, want := range tt.enabled {
+				if got[name] != want {
+					t.Errorf("collector %v enabled = %v, want %v", name, got[name], want)
+				}
+			}
+		})
+	}
+}
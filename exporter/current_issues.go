@@ -0,0 +1,134 @@
+package exporter
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+func init() {
+	registerCollector("current_issues", true, (*RethinkdbExporter).collectCurrentIssues)
+}
+
+// issueTypes are documented issue types, their counts are exported as 0 when there are no issues of the type
+var issueTypes = []string{
+	"log_write_error",
+	"server_name_collision",
+	"db_name_collision",
+	"table_name_collision",
+	"outdated_index",
+	"table_availability",
+	"memory_error",
+	"non_transitive_error",
+}
+
+type currentIssue struct {
+	Type     string `rethinkdb:"type"`
+	Critical bool   `rethinkdb:"critical"`
+	Info     struct {
+		Servers []string `rethinkdb:"servers"`
+		Name    string   `rethinkdb:"name"`
+		DB      string   `rethinkdb:"db"`
+		Table   string   `rethinkdb:"table"`
+		Tables  []struct {
+			DB    string `rethinkdb:"db"`
+			Table string `rethinkdb:"table"`
+		} `rethinkdb:"tables"`
+	} `rethinkdb:"info"`
+}
+
+type issueKey struct {
+	Type     string
+	Critical string
+}
+
+type issueDetail struct {
+	Type     string
+	Critical string
+	Server   string
+	Name     string
+	DB       string
+	Table    string
+}
+
+// collectCurrentIssues exports problems of the cluster from current_issues system table.
+// Counts of all known issue types are exported, so a resolved issue is 0 instead of a missing series.
+// Issue details are exported only with bounded labels: servers, names, dbs and tables, never messages.
+// Details are filtered with server, db and table filters, counts are not.
+func (e *RethinkdbExporter) collectCurrentIssues(ctx context.Context, rconn r.QueryExecutor, ch chan<- prometheus.Metric) int {
+	var issues []currentIssue
+	err := r.DB(r.SystemDatabase).Table(r.CurrentIssuesSystemTable).ReadAll(&issues, rconn, r.RunOpts{Context: ctx})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to query current issues table")
+		return 1
+	}
+
+	counts := make(map[issueKey]int)
+	for _, typ := range issueTypes {
+		counts[issueKey{Type: typ, Critical: "true"}] = 0
+		counts[issueKey{Type: typ, Critical: "false"}] = 0
+	}
+	details := make(map[issueDetail]bool)
+	for _, issue := range issues {
+		critical := strconv.FormatBool(issue.Critical)
+		counts[issueKey{Type: issue.Type, Critical: critical}]++
+
+		for _, detail := range issueDetails(issue) {
+			if !e.issueDetailAllowed(issue.Type, detail) {
+				continue
+			}
+			detail.Type = issue.Type
+			detail.Critical = critical
+			details[detail] = true
+		}
+	}
+
+	for key, count := range counts {
+		ch <- prometheus.MustNewConstMetric(e.metrics.currentIssues, prometheus.GaugeValue, float64(count), key.Type, key.Critical)
+	}
+	for d := range details {
+		ch <- prometheus.MustNewConstMetric(e.metrics.currentIssueInfo, prometheus.GaugeValue, 1, d.Type, d.Critical, d.Server, d.Name, d.DB, d.Table)
+	}
+	return 0
+}
+
+// issueDetails returns the objects affected by the issue, labels not relevant to the issue type are empty
+func issueDetails(issue currentIssue) []issueDetail {
+	var details []issueDetail
+	for _, server := range issue.Info.Servers {
+		details = append(details, issueDetail{Server: server})
+	}
+	for _, table := range issue.Info.Tables {
+		details = append(details, issueDetail{DB: table.DB, Table: table.Table})
+	}
+	if issue.Info.Name != "" || issue.Info.Table != "" {
+		details = append(details, issueDetail{Name: issue.Info.Name, DB: issue.Info.DB, Table: issue.Info.Table})
+	}
+	return details
+}
+
+// issueDetailAllowed checks the affected server, db or table with filters.
+// Name is a server, db or table name depending on the issue type.
+func (e *RethinkdbExporter) issueDetailAllowed(typ string, d issueDetail) bool {
+	if d.Server != "" && !e.serverAllowed(d.Server) {
+		return false
+	}
+	switch typ {
+	case "server_name_collision":
+		return e.serverAllowed(d.Name)
+	case "db_name_collision":
+		return e.opts.DBFilter.Match(d.Name)
+	case "table_name_collision":
+		return e.tableAllowed(d.DB, d.Name)
+	}
+	if d.Table != "" {
+		return e.tableAllowed(d.DB, d.Table)
+	}
+	if d.DB != "" {
+		return e.opts.DBFilter.Match(d.DB)
+	}
+	return true
+}
@@ -0,0 +1,358 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/rs/zerolog/log"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+// customQueryExpiration is duration after that results of the query not collected anymore are removed from cache
+const customQueryExpiration = 10 * time.Minute
+
+// customQueriesCollector runs custom queries, probed targets run them only if their module enables it
+const customQueriesCollector = "custom_queries"
+
+func init() {
+	registerCollector(customQueriesCollector, true, (*RethinkdbExporter).collectCustomQueries)
+}
+
+// CustomQuery defines a metric collected with user defined ReQL query.
+// Query is either JSON serialised ReQL term or a subset of table, filter, group and count terms.
+// Query result is a number or a sequence of numbers or documents, every document is a sample of the metric.
+type CustomQuery struct {
+	// Name of the metric, prefixed with the namespace
+	Name string
+	// Help of the metric
+	Help string
+	// Type of the metric: gauge or counter, gauge if empty
+	Type string
+	// Interval of running the query, cached result is exported between runs, zero means every scrape
+	Interval time.Duration
+
+	// ReQL is JSON serialised ReQL term, e.g. [43,[[15,[[14,["app"]],"queue"]]]] for r.db("app").table("queue").count()
+	ReQL string
+
+	// DB is a database of the table
+	DB string
+	// Table to query
+	Table string
+	// Filter selects documents with fields equal to the values
+	Filter map[string]interface{}
+	// Group groups documents by the field, result documents have group and reduction fields
+	Group string
+	// Count counts documents
+	Count bool
+
+	// Labels maps label names to fields of the result documents, dot separates nested fields
+	Labels map[string]string
+	// Value is the field of the result documents with the metric value, empty if the result is a number
+	Value string
+}
+
+// customQuery is a prepared custom query
+type customQuery struct {
+	CustomQuery
+
+	term       r.Term
+	desc       *prometheus.Desc
+	valueType  prometheus.ValueType
+	labelNames []string
+}
+
+type customQueryKey struct {
+	rconn r.QueryExecutor
+	query int
+}
+
+type customQueryResult struct {
+	metrics []prometheus.Metric
+	updated time.Time
+}
+
+// customQueryCache keeps results of the custom queries between runs
+type customQueryCache struct {
+	m       sync.Mutex
+	results map[customQueryKey]customQueryResult
+}
+
+func newCustomQueryCache() *customQueryCache {
+	return &customQueryCache{
+		results: make(map[customQueryKey]customQueryResult),
+	}
+}
+
+func (c *customQueryCache) get(key customQueryKey) (customQueryResult, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	res, ok := c.results[key]
+	return res, ok
+}
+
+func (c *customQueryCache) set(key customQueryKey, res customQueryResult) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.results[key] = res
+}
+
+// expire removes results updated before the time
+func (c *customQueryCache) expire(since time.Time) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for key, res := range c.results {
+		if res.updated.Before(since) {
+			delete(c.results, key)
+		}
+	}
+}
+
+// initCustomQueries validates custom queries and prepares their terms and descriptions.
+// Names must not clash with the exporter metrics, metrics of the default registry served with them and other custom queries.
+func (e *RethinkdbExporter) initCustomQueries() error {
+	e.customQueries = make([]*customQuery, 0, len(e.opts.CustomQueries))
+	if len(e.opts.CustomQueries) == 0 {
+		return nil
+	}
+
+	reserved := defaultRegistryNames()
+	for _, spec := range e.opts.CustomQueries {
+		q, err := e.prepareCustomQuery(spec, reserved)
+		if err != nil {
+			return fmt.Errorf("custom query '%v': %v", spec.Name, err)
+		}
+		e.customQueries = append(e.customQueries, q)
+	}
+	return nil
+}
+
+func (e *RethinkdbExporter) prepareCustomQuery(spec CustomQuery, reserved map[string]bool) (*customQuery, error) {
+	if spec.Name == "" {
+		return nil, errors.New("metric name is not set")
+	}
+	fqName := prometheus.BuildFQName(e.opts.Namespace, "", spec.Name)
+	if !model.IsValidMetricName(model.LabelValue(fqName)) {
+		return nil, fmt.Errorf("invalid metric name '%v'", fqName)
+	}
+	if e.metricNames[fqName] || reserved[fqName] {
+		return nil, fmt.Errorf("metric name '%v' is already used", fqName)
+	}
+	for label := range spec.Labels {
+		if !model.LabelName(label).IsValid() || strings.HasPrefix(label, model.ReservedLabelPrefix) {
+			return nil, fmt.Errorf("invalid label name '%v'", label)
+		}
+		if _, ok := e.opts.ConstLabels[label]; ok {
+			return nil, fmt.Errorf("label '%v' is a const label", label)
+		}
+	}
+
+	q := &customQuery{CustomQuery: spec}
+
+	switch spec.Type {
+	case "", "gauge":
+		q.valueType = prometheus.GaugeValue
+	case "counter":
+		q.valueType = prometheus.CounterValue
+	default:
+		return nil, fmt.Errorf("unsupported metric type '%v'", spec.Type)
+	}
+
+	switch {
+	case spec.ReQL != "" && spec.Table != "":
+		return nil, errors.New("reql and table can not be both set")
+	case spec.ReQL != "":
+		q.term = r.RawQuery([]byte(spec.ReQL))
+	case spec.Table != "":
+		if spec.Group != "" && !spec.Count {
+			return nil, errors.New("group requires count")
+		}
+		term := r.DB(spec.DB).Table(spec.Table)
+		if len(spec.Filter) != 0 {
+			term = term.Filter(spec.Filter)
+		}
+		if spec.Group != "" {
+			term = term.Group(spec.Group)
+		}
+		if spec.Count {
+			term = term.Count()
+		}
+		if spec.Group != "" {
+			term = term.Ungroup()
+		}
+		q.term = term
+	default:
+		return nil, errors.New("reql or table must be set")
+	}
+
+	for label := range spec.Labels {
+		q.labelNames = append(q.labelNames, label)
+	}
+	sort.Strings(q.labelNames)
+	q.desc = e.newDesc(spec.Name, spec.Help, q.labelNames)
+
+	return q, nil
+}
+
+// defaultRegistryNames returns names of the metrics in the default registry and of the metrics handler instrumentation
+func defaultRegistryNames() map[string]bool {
+	names := map[string]bool{
+		"promhttp_metric_handler_requests_total":     true,
+		"promhttp_metric_handler_requests_in_flight": true,
+	}
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to gather default registry")
+	}
+	for _, f := range families {
+		names[f.GetName()] = true
+	}
+	return names
+}
+
+// collectCustomQueries runs custom queries or sends their cached results if interval has not passed
+func (e *RethinkdbExporter) collectCustomQueries(ctx context.Context, rconn r.QueryExecutor, ch chan<- prometheus.Metric) int {
+	errcount := 0
+	for i, q := range e.customQueries {
+		key := customQueryKey{rconn: rconn, query: i}
+
+		cached, ok := e.customQueryCache.get(key)
+		if !ok || time.Since(cached.updated) >= q.Interval {
+			metrics, err := q.run(ctx, rconn)
+			if err != nil {
+				log.Warn().Err(err).Str("metric", q.Name).Msg("failed to run custom query")
+				errcount++
+				continue
+			}
+			cached = customQueryResult{metrics: metrics, updated: time.Now()}
+			e.customQueryCache.set(key, cached)
+		}
+
+		for _, m := range cached.metrics {
+			ch <- m
+		}
+	}
+
+	maxInterval := time.Duration(0)
+	for _, q := range e.customQueries {
+		if q.Interval > maxInterval {
+			maxInterval = q.Interval
+		}
+	}
+	e.customQueryCache.expire(time.Now().Add(-customQueryExpiration - maxInterval))
+
+	return errcount
+}
+
+// run executes the query and converts result rows into metrics.
+// Rows with the same label values would be duplicate series failing the whole gather, so such results are rejected.
+func (q *customQuery) run(ctx context.Context, rconn r.QueryExecutor) ([]prometheus.Metric, error) {
+	var rows []interface{}
+	err := q.term.ReadAll(&rows, rconn, r.RunOpts{Context: ctx})
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]prometheus.Metric, 0, len(rows))
+	seen := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		value, labelValues, err := q.rowSample(row)
+		if err != nil {
+			return nil, err
+		}
+		key := strings.Join(labelValues, "\xff")
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate result rows with label values %q", labelValues)
+		}
+		seen[key] = true
+
+		m, err := prometheus.NewConstMetric(q.desc, q.valueType, value, labelValues...)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+func (q *customQuery) rowMetric(row interface{}) (prometheus.Metric, error) {
+	value, labelValues, err := q.rowSample(row)
+	if err != nil {
+		return nil, err
+	}
+	return prometheus.NewConstMetric(q.desc, q.valueType, value, labelValues...)
+}
+
+// rowSample returns the metric value and label values of the result row
+func (q *customQuery) rowSample(row interface{}) (float64, []string, error) {
+	doc, isDoc := row.(map[string]interface{})
+	if !isDoc {
+		if len(q.labelNames) != 0 || q.Value != "" {
+			return 0, nil, fmt.Errorf("result row is not a document: %v", row)
+		}
+		value, err := toFloat(row)
+		if err != nil {
+			return 0, nil, err
+		}
+		return value, nil, nil
+	}
+
+	if q.Value == "" {
+		return 0, nil, errors.New("value field is not set for document results")
+	}
+	field, ok := lookupField(doc, q.Value)
+	if !ok {
+		return 0, nil, fmt.Errorf("value field '%v' is missing", q.Value)
+	}
+	value, err := toFloat(field)
+	if err != nil {
+		return 0, nil, fmt.Errorf("value field '%v': %v", q.Value, err)
+	}
+
+	labelValues := make([]string, 0, len(q.labelNames))
+	for _, label := range q.labelNames {
+		field, _ := lookupField(doc, q.Labels[label])
+		if field == nil {
+			labelValues = append(labelValues, "")
+			continue
+		}
+		labelValues = append(labelValues, fmt.Sprint(field))
+	}
+	return value, labelValues, nil
+}
+
+// lookupField returns the field of the document by path with dot separated nested fields
+func lookupField(doc map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = doc
+	for _, name := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = m[name]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case bool:
+		return boolToFloat(v), nil
+	default:
+		return 0, fmt.Errorf("value is not a number: %v", v)
+	}
+}
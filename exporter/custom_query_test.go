@@ -0,0 +1,244 @@
+package exporter
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+func TestNewCustomQueryValidation(t *testing.T) {
+	valid := CustomQuery{Name: "queue_depth", Help: "Queue depth", Table: "queue", Count: true}
+	withName := func(name string) CustomQuery {
+		q := valid
+		q.Name = name
+		return q
+	}
+	withLabels := func(labels map[string]string) CustomQuery {
+		q := valid
+		q.Labels = labels
+		q.Value = "reduction"
+		return q
+	}
+
+	tests := []struct {
+		name        string
+		queries     []CustomQuery
+		constLabels prometheus.Labels
+		wantErr     string
+	}{
+		{name: "valid", queries: []CustomQuery{valid, withName("failed_jobs")}},
+		{name: "valid labels", queries: []CustomQuery{withLabels(map[string]string{"priority": "group"})}},
+		{name: "invalid metric name", queries: []CustomQuery{withName("queue-depth")}, wantErr: "invalid metric name"},
+		{name: "built-in name", queries: []CustomQuery{withName("up")}, wantErr: "already used"},
+		{name: "built-in legacy name", queries: []CustomQuery{withName("cluster_client_connections")}, wantErr: "already used"},
+		{name: "duplicate name", queries: []CustomQuery{valid, valid}, wantErr: "already used"},
+		{name: "invalid label name", queries: []CustomQuery{withLabels(map[string]string{"bad-label": "group"})}, wantErr: "invalid label name"},
+		{name: "reserved label name", queries: []CustomQuery{withLabels(map[string]string{"__name__": "group"})}, wantErr: "invalid label name"},
+		{
+			name:        "label repeats const label",
+			queries:     []CustomQuery{withLabels(map[string]string{"server": "group"})},
+			constLabels: prometheus.Labels{"server": "a"},
+			wantErr:     "const label",
+		},
+		{name: "no query", queries: []CustomQuery{{Name: "empty"}}, wantErr: "reql or table must be set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := New("/metrics", "", nil, nil, Options{
+				Namespace:     "rethinkdb",
+				ConstLabels:   tt.constLabels,
+				CustomQueries: tt.queries,
+			})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if err := prometheus.NewRegistry().Register(e); err != nil {
+					t.Fatalf("failed to register exporter: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCustomQueryRowMetric(t *testing.T) {
+	e := &RethinkdbExporter{opts: Options{Namespace: "rethinkdb"}}
+
+	tests := []struct {
+		name      string
+		spec      CustomQuery
+		row       interface{}
+		wantValue float64
+		wantLabel map[string]string
+		wantErr   bool
+	}{
+		{
+			name:      "number",
+			spec:      CustomQuery{Name: "count", ReQL: "[]"},
+			row:       float64(42),
+			wantValue: 42,
+		},
+		{
+			name:      "bool",
+			spec:      CustomQuery{Name: "flag", ReQL: "[]"},
+			row:       true,
+			wantValue: 1,
+		},
+		{
+			name:      "grouped document",
+			spec:      CustomQuery{Name: "grouped", ReQL: "[]", Labels: map[string]string{"priority": "group"}, Value: "reduction"},
+			row:       map[string]interface{}{"group": "high", "reduction": float64(3)},
+			wantValue: 3,
+			wantLabel: map[string]string{"priority": "high"},
+		},
+		{
+			name: "nested fields",
+			spec: CustomQuery{Name: "nested", ReQL: "[]", Labels: map[string]string{"host": "server.name"}, Value: "stats.count"},
+			row: map[string]interface{}{
+				"server": map[string]interface{}{"name": "db1"},
+				"stats":  map[string]interface{}{"count": float64(7)},
+			},
+			wantValue: 7,
+			wantLabel: map[string]string{"host": "db1"},
+		},
+		{
+			name:      "missing label field",
+			spec:      CustomQuery{Name: "missing_label", ReQL: "[]", Labels: map[string]string{"host": "server"}, Value: "count"},
+			row:       map[string]interface{}{"count": float64(1)},
+			wantValue: 1,
+			wantLabel: map[string]string{"host": ""},
+		},
+		{
+			name:    "missing value field",
+			spec:    CustomQuery{Name: "missing_value", ReQL: "[]", Value: "count"},
+			row:     map[string]interface{}{"other": float64(1)},
+			wantErr: true,
+		},
+		{
+			name:    "document without value field",
+			spec:    CustomQuery{Name: "no_value", ReQL: "[]"},
+			row:     map[string]interface{}{"count": float64(1)},
+			wantErr: true,
+		},
+		{
+			name:    "number with labels",
+			spec:    CustomQuery{Name: "number_labels", ReQL: "[]", Labels: map[string]string{"host": "server"}},
+			row:     float64(1),
+			wantErr: true,
+		},
+		{
+			name:    "string value",
+			spec:    CustomQuery{Name: "string_value", ReQL: "[]"},
+			row:     "text",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := e.prepareCustomQuery(tt.spec, nil)
+			if err != nil {
+				t.Fatalf("failed to prepare query: %v", err)
+			}
+			m, err := q.rowMetric(tt.row)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var out dto.Metric
+			if err := m.Write(&out); err != nil {
+				t.Fatalf("failed to write metric: %v", err)
+			}
+			if got := out.GetGauge().GetValue(); got != tt.wantValue {
+				t.Errorf("value = %v, want %v", got, tt.wantValue)
+			}
+			labels := make(map[string]string)
+			for _, l := range out.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			for name, want := range tt.wantLabel {
+				if labels[name] != want {
+					t.Errorf("label %v = %q, want %q", name, labels[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestCustomQueryRunDuplicateLabels(t *testing.T) {
+	e := &RethinkdbExporter{opts: Options{Namespace: "rethinkdb"}}
+	q, err := e.prepareCustomQuery(CustomQuery{
+		Name:   "grouped",
+		ReQL:   "[]",
+		Labels: map[string]string{"priority": "group"},
+		Value:  "reduction",
+	}, nil)
+	if err != nil {
+		t.Fatalf("failed to prepare query: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		rows    []interface{}
+		wantLen int
+		wantErr bool
+	}{
+		{
+			name: "unique",
+			rows: []interface{}{
+				map[string]interface{}{"group": "high", "reduction": float64(3)},
+				map[string]interface{}{"group": "low", "reduction": float64(1)},
+			},
+			wantLen: 2,
+		},
+		{
+			name: "duplicate",
+			rows: []interface{}{
+				map[string]interface{}{"group": "high", "reduction": float64(3)},
+				map[string]interface{}{"group": "high", "reduction": float64(1)},
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing and empty label",
+			rows: []interface{}{
+				map[string]interface{}{"reduction": float64(3)},
+				map[string]interface{}{"group": "", "reduction": float64(1)},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := r.NewMock()
+			mock.On(q.term).Return(tt.rows, nil)
+
+			metrics, err := q.run(context.Background(), mock)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(metrics) != tt.wantLen {
+				t.Errorf("got %d metrics, want %d", len(metrics), tt.wantLen)
+			}
+		})
+	}
+}
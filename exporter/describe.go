@@ -1,7 +1,11 @@
 package exporter
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
 )
 
 const (
@@ -12,85 +16,429 @@ const (
 // Describe sends metrics descriptions to the prometheus chan
 func (e *RethinkdbExporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- e.metrics.clusterClientConnections
+	ch <- e.metrics.clusterClientsActive
+	ch <- e.metrics.clusterQueriesPerSecond
 	ch <- e.metrics.clusterDocsPerSecond
 
 	ch <- e.metrics.serverClientConnections
+	ch <- e.metrics.serverClientsActive
 	ch <- e.metrics.serverQueriesPerSecond
+	ch <- e.metrics.serverQueriesTotal
 	ch <- e.metrics.serverDocsPerSecond
+	ch <- e.metrics.serverDocsTotal
+
+	ch <- e.metrics.serverUp
+	ch <- e.metrics.serverUptimeSeconds
+	ch <- e.metrics.serverConnectedSeconds
+	ch <- e.metrics.serverCacheSizeBytes
+	ch <- e.metrics.serverInfo
 
 	ch <- e.metrics.tableDocsPerSecond
 	if e.metrics.tableRowsCount != nil {
 		ch <- e.metrics.tableRowsCount
+		ch <- e.metrics.tableRowsCountAgeSeconds
 	}
 
+	ch <- e.metrics.tableReadyForOutdatedReads
+	ch <- e.metrics.tableReadyForReads
+	ch <- e.metrics.tableReadyForWrites
+	ch <- e.metrics.tableAllReplicasReady
+	ch <- e.metrics.tableShardPrimaryReplicas
+	ch <- e.metrics.tableShardReplicaState
+
+	ch <- e.metrics.currentIssues
+	ch <- e.metrics.currentIssueInfo
+
+	ch <- e.metrics.jobsActive
+	ch <- e.metrics.indexConstructionProgress
+	ch <- e.metrics.backfillProgress
+	ch <- e.metrics.queryLongestDurationSeconds
+
+	ch <- e.metrics.tableConfigInfo
+	ch <- e.metrics.tableConfigShards
+	ch <- e.metrics.tableShardConfigReplicas
+	ch <- e.metrics.tableShardConfigNonvotingReplicas
+	ch <- e.metrics.dbConfigInfo
+	ch <- e.metrics.serverConfigInfo
+	ch <- e.metrics.serverConfigCacheSizeBytes
+
+	ch <- e.metrics.nodeUp
+	ch <- e.metrics.nodeConnectDurationSeconds
+	ch <- e.metrics.nodeInfo
+	ch <- e.metrics.nodePeerConnected
+
 	ch <- e.metrics.tableReplicaDocsPerSecond
+	ch <- e.metrics.tableReplicaDocsTotal
 	ch <- e.metrics.tableReplicaCacheBytes
 	ch <- e.metrics.tableReplicaIO
+	ch <- e.metrics.tableReplicaIOTotal
 	ch <- e.metrics.tableReplicaDataBytes
+	ch <- e.metrics.tableReplicaGarbageBytes
+	ch <- e.metrics.tableReplicaMetadataBytes
+	ch <- e.metrics.tableReplicaPreallocatedBytes
 
 	ch <- e.metrics.scrapeLatency
 	ch <- e.metrics.scrapeErrors
+	ch <- e.metrics.scrapeTimeout
+
+	ch <- e.metrics.up
+	ch <- e.metrics.collectorSuccess
+	ch <- e.metrics.collectorDurationSeconds
+
+	ch <- e.metrics.snapshotAgeSeconds
+	ch <- e.metrics.snapshotDurationSeconds
+
+	ch <- e.metrics.configLastReloadSuccessful
+	ch <- e.metrics.configLastReloadSuccessTimestamp
+
+	ch <- e.metrics.clientCertificateExpiryTimestamp
+
+	ch <- e.metrics.sessionCircuitBreakerState
+	ch <- e.metrics.sessionReconnectsTotal
+	ch <- e.metrics.sessionConnectFailuresTotal
+
+	for _, q := range e.customQueries {
+		ch <- q.desc
+	}
+	e.describeLatencyProbe(ch)
+}
+
+// newDesc creates metric description with the namespace and the constant labels
+func (e *RethinkdbExporter) newDesc(name, help string, variableLabels []string) *prometheus.Desc {
+	return e.newDescWithNamespace(e.opts.Namespace, name, help, variableLabels)
+}
+
+// newLegacyDesc creates description of the metric which existed before the namespace,
+// it is unprefixed with legacy names
+func (e *RethinkdbExporter) newLegacyDesc(name, help string, variableLabels []string) *prometheus.Desc {
+	namespace := e.opts.Namespace
+	if e.opts.LegacyNames {
+		namespace = ""
+	}
+	return e.newDescWithNamespace(namespace, name, help, variableLabels)
+}
+
+func (e *RethinkdbExporter) newDescWithNamespace(namespace, name, help string, variableLabels []string) *prometheus.Desc {
+	fqName := prometheus.BuildFQName(namespace, "", name)
+	e.registerVariableLabels(fqName, variableLabels)
+	return prometheus.NewDesc(fqName, help, variableLabels, e.opts.ConstLabels)
+}
+
+// registerVariableLabels remembers the metric and its variable labels to validate constant labels and custom query names
+func (e *RethinkdbExporter) registerVariableLabels(fqName string, variableLabels []string) {
+	if e.metricNames == nil {
+		e.metricNames = make(map[string]bool)
+		e.variableLabels = make(map[string]string)
+	}
+	e.metricNames[fqName] = true
+	for _, label := range variableLabels {
+		e.variableLabels[label] = fqName
+	}
+}
+
+// validateConstLabels checks that constant labels are valid and don't repeat variable labels of the metrics
+func (e *RethinkdbExporter) validateConstLabels() error {
+	for name := range e.opts.ConstLabels {
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return fmt.Errorf("invalid const label name '%v'", name)
+		}
+		if metric, ok := e.variableLabels[name]; ok {
+			return fmt.Errorf("const label '%v' is a variable label of metric '%v'", name, metric)
+		}
+	}
+	return nil
+}
+
+// validateDescs registers the descriptions in a throwaway registry as they are served
+// and checks them against the default registry gathered with them,
+// so invalid or inconsistent metrics fail at startup or reload instead of every scrape
+func (e *RethinkdbExporter) validateDescs() error {
+	err := prometheus.NewRegistry().Register(&scrapeCollector{e: e, rconn: e.rconn, main: true})
+	if err != nil {
+		return fmt.Errorf("invalid metrics: %v", err)
+	}
+	reserved := defaultRegistryNames()
+	for name := range e.metricNames {
+		if reserved[name] {
+			return fmt.Errorf("invalid metrics: metric '%v' is already exported by the default registry", name)
+		}
+	}
+	return nil
 }
 
 func (e *RethinkdbExporter) initMetrics() {
-	e.metrics.clusterClientConnections = prometheus.NewDesc(
+	e.metrics.clusterClientConnections = e.newLegacyDesc(
 		"cluster_client_connections",
 		"Total number of connections from the cluster",
-		nil, nil,
-	)
-	e.metrics.clusterDocsPerSecond = prometheus.NewDesc(
+		nil)
+	e.metrics.clusterClientsActive = e.newDesc(
+		"cluster_clients_active",
+		"Total number of clients running queries in the cluster",
+		nil)
+	e.metrics.clusterQueriesPerSecond = e.newDesc(
+		"cluster_queries_per_second",
+		"Total number of queries per second from the cluster",
+		nil)
+	e.metrics.clusterDocsPerSecond = e.newLegacyDesc(
 		"cluster_docs_per_second",
 		"Total number of reads and writes of documents per second from the cluster",
-		[]string{"operation"}, nil)
+		[]string{"operation"})
 
-	e.metrics.serverClientConnections = prometheus.NewDesc(
+	e.metrics.serverClientConnections = e.newLegacyDesc(
 		"server_client_connections",
 		"Number of client connections to the server",
-		[]string{"server"}, nil)
-	e.metrics.serverQueriesPerSecond = prometheus.NewDesc(
+		[]string{"server"})
+	e.metrics.serverClientsActive = e.newDesc(
+		"server_clients_active",
+		"Number of clients running queries on the server",
+		[]string{"server"})
+	e.metrics.serverQueriesPerSecond = e.newLegacyDesc(
 		"server_queries_per_second",
 		"Number of queries per second from the server",
-		[]string{"server"}, nil)
-	e.metrics.serverDocsPerSecond = prometheus.NewDesc(
+		[]string{"server"})
+	e.metrics.serverQueriesTotal = e.newDesc(
+		"server_queries_total",
+		"Total number of queries executed by the server",
+		[]string{"server"})
+	e.metrics.serverDocsPerSecond = e.newLegacyDesc(
 		"server_docs_per_second",
 		"Total number of reads and writes of documents per second from the server",
-		[]string{"server", "operation"}, nil)
+		[]string{"server", "operation"})
+	e.metrics.serverDocsTotal = e.newDesc(
+		"server_docs_total",
+		"Total number of documents read and written by the server",
+		[]string{"server", "operation"})
+
+	e.metrics.serverUp = e.newDesc(
+		"server_up",
+		"Equals 1 if the server is connected to the cluster, 0 if it is known by peers but disconnected",
+		[]string{"server"})
+	e.metrics.serverUptimeSeconds = e.newDesc(
+		"server_uptime_seconds",
+		"Number of seconds since the server process was started",
+		[]string{"server"})
+	e.metrics.serverConnectedSeconds = e.newDesc(
+		"server_connected_seconds",
+		"Number of seconds since the server was connected to the cluster",
+		[]string{"server"})
+	e.metrics.serverCacheSizeBytes = e.newDesc(
+		"server_cache_size_bytes",
+		"Size of the server cache in bytes",
+		[]string{"server"})
+	e.metrics.serverInfo = e.newDesc(
+		"server_info",
+		"Server process and network information, value is always 1",
+		[]string{"server", "hostname", "version", "reql_port", "http_admin_port", "cluster_port"})
 
-	e.metrics.tableDocsPerSecond = prometheus.NewDesc(
+	e.metrics.tableDocsPerSecond = e.newLegacyDesc(
 		"table_docs_per_second",
 		"Number of reads and writes of documents per second from the table",
-		[]string{"db", "table", "operation"}, nil)
+		[]string{"db", "table", "operation"})
 
-	if e.collectTableStats {
-		e.metrics.tableRowsCount = prometheus.NewDesc(
+	if e.opts.TableDocsEstimates {
+		e.metrics.tableRowsCount = e.newLegacyDesc(
 			"table_rows_count",
 			"Approximate number of rows in the table",
-			[]string{"db", "table"}, nil)
+			[]string{"db", "table"})
+		e.metrics.tableRowsCountAgeSeconds = e.newDesc(
+			"table_rows_count_age_seconds",
+			"Number of seconds since the approximate number of rows in the table was refreshed",
+			[]string{"db", "table"})
 	}
 
-	e.metrics.tableReplicaDocsPerSecond = prometheus.NewDesc(
+	e.metrics.tableReadyForOutdatedReads = e.newDesc(
+		"table_ready_for_outdated_reads",
+		"Equals 1 if the table is ready for reads with outdated read mode",
+		[]string{"db", "table"})
+	e.metrics.tableReadyForReads = e.newDesc(
+		"table_ready_for_reads",
+		"Equals 1 if the table is ready for reads with single read mode",
+		[]string{"db", "table"})
+	e.metrics.tableReadyForWrites = e.newDesc(
+		"table_ready_for_writes",
+		"Equals 1 if the table is ready for writes",
+		[]string{"db", "table"})
+	e.metrics.tableAllReplicasReady = e.newDesc(
+		"table_all_replicas_ready",
+		"Equals 1 if all replicas of the table are ready",
+		[]string{"db", "table"})
+	e.metrics.tableShardPrimaryReplicas = e.newDesc(
+		"tableshard_primary_replicas",
+		"Number of primary replicas of the table shard",
+		[]string{"db", "table", "shard"})
+	e.metrics.tableShardReplicaState = e.newDesc(
+		"tableshard_replica_state",
+		"State of the table shard replica on the server, value is always 1",
+		[]string{"db", "table", "shard", "server", "state"})
+
+	e.metrics.currentIssues = e.newDesc(
+		"current_issues",
+		"Number of current issues of the cluster by type",
+		[]string{"type", "critical"})
+	e.metrics.currentIssueInfo = e.newDesc(
+		"current_issue_info",
+		"Servers, names and tables affected by the current issue, value is always 1",
+		[]string{"type", "critical", "server", "name", "db", "table"})
+
+	e.metrics.jobsActive = e.newDesc(
+		"jobs_active",
+		"Number of jobs running on the server by type",
+		[]string{"type", "server"})
+	e.metrics.indexConstructionProgress = e.newDesc(
+		"index_construction_progress",
+		"Progress of the secondary index construction from 0 to 1",
+		[]string{"db", "table", "index"})
+	e.metrics.backfillProgress = e.newDesc(
+		"backfill_progress",
+		"Progress of the table backfill to the destination server from 0 to 1",
+		[]string{"db", "table", "destination_server"})
+	e.metrics.queryLongestDurationSeconds = e.newDesc(
+		"query_longest_duration_seconds",
+		"Duration of the longest running query in seconds",
+		nil)
+
+	e.metrics.tableConfigInfo = e.newDesc(
+		"table_config_info",
+		"Table configuration, value is always 1",
+		[]string{"db", "table", "primary_key", "durability", "write_acks"})
+	e.metrics.tableConfigShards = e.newDesc(
+		"table_config_shards",
+		"Number of configured shards of the table",
+		[]string{"db", "table"})
+	e.metrics.tableShardConfigReplicas = e.newDesc(
+		"tableshard_config_replicas",
+		"Number of configured replicas of the table shard",
+		[]string{"db", "table", "shard", "primary_replica"})
+	e.metrics.tableShardConfigNonvotingReplicas = e.newDesc(
+		"tableshard_config_nonvoting_replicas",
+		"Number of configured nonvoting replicas of the table shard",
+		[]string{"db", "table", "shard"})
+	e.metrics.dbConfigInfo = e.newDesc(
+		"db_config_info",
+		"Configured database, value is always 1",
+		[]string{"db"})
+	e.metrics.serverConfigInfo = e.newDesc(
+		"server_config_info",
+		"Server configuration with comma separated sorted tags, value is always 1",
+		[]string{"server", "tags", "cache_size_mb"})
+	e.metrics.serverConfigCacheSizeBytes = e.newDesc(
+		"server_config_cache_size_bytes",
+		"Configured size of the server cache in bytes, absent if it is auto",
+		[]string{"server"})
+
+	e.metrics.nodeUp = e.newDesc(
+		"node_up",
+		"Equals 1 if the node is reachable with a direct connection",
+		[]string{"address"})
+	e.metrics.nodeConnectDurationSeconds = e.newDesc(
+		"node_connect_duration_seconds",
+		"Duration of the direct connection to the node in seconds",
+		[]string{"address"})
+	e.metrics.nodeInfo = e.newDesc(
+		"node_info",
+		"Server answering on the node address, value is always 1",
+		[]string{"address", "server"})
+	e.metrics.nodePeerConnected = e.newDesc(
+		"node_peer_connected",
+		"Equals 1 if the node sees the peer server connected to it",
+		[]string{"address", "server", "peer"})
+
+	e.metrics.tableReplicaDocsPerSecond = e.newLegacyDesc(
 		"tablereplica_docs_per_second",
 		"Number of reads and writes of documents per second from the table replica",
-		[]string{"db", "table", "server", "operation"}, nil)
-	e.metrics.tableReplicaCacheBytes = prometheus.NewDesc(
+		[]string{"db", "table", "server", "operation"})
+	e.metrics.tableReplicaDocsTotal = e.newDesc(
+		"tablereplica_docs_total",
+		"Total number of documents read and written by the table replica",
+		[]string{"db", "table", "server", "operation"})
+	e.metrics.tableReplicaCacheBytes = e.newLegacyDesc(
 		"tablereplica_cache_bytes",
 		"Table replica cache size in bytes",
-		[]string{"db", "table", "server"}, nil)
-	e.metrics.tableReplicaIO = prometheus.NewDesc(
+		[]string{"db", "table", "server"})
+	e.metrics.tableReplicaIO = e.newLegacyDesc(
 		"tablereplica_io",
 		"Table replica reads and writes of bytes per second",
-		[]string{"db", "table", "server", "operation"}, nil)
-	e.metrics.tableReplicaDataBytes = prometheus.NewDesc(
+		[]string{"db", "table", "server", "operation"})
+	e.metrics.tableReplicaIOTotal = e.newDesc(
+		"tablereplica_io_bytes_total",
+		"Total number of bytes read and written by the table replica",
+		[]string{"db", "table", "server", "operation"})
+	e.metrics.tableReplicaDataBytes = e.newLegacyDesc(
 		"tablereplica_data_bytes",
 		"Table replica size in stored bytes",
-		[]string{"db", "table", "server"}, nil)
+		[]string{"db", "table", "server"})
+	e.metrics.tableReplicaGarbageBytes = e.newDesc(
+		"tablereplica_garbage_bytes",
+		"Table replica disk space in bytes occupied by garbage to be collected",
+		[]string{"db", "table", "server"})
+	e.metrics.tableReplicaMetadataBytes = e.newDesc(
+		"tablereplica_metadata_bytes",
+		"Table replica disk space in bytes occupied by metadata",
+		[]string{"db", "table", "server"})
+	e.metrics.tableReplicaPreallocatedBytes = e.newDesc(
+		"tablereplica_preallocated_bytes",
+		"Table replica disk space in bytes preallocated and not used yet",
+		[]string{"db", "table", "server"})
 
-	e.metrics.scrapeLatency = prometheus.NewDesc(
+	e.metrics.scrapeLatency = e.newLegacyDesc(
 		"scrape_latency",
 		"Latency of collecting scrape",
-		nil, nil)
-	e.metrics.scrapeErrors = prometheus.NewDesc(
+		nil)
+	e.metrics.scrapeErrors = e.newLegacyDesc(
 		"scrape_errors",
 		"Number of errors while collecting scrape",
-		nil, nil)
+		nil)
+	e.metrics.scrapeTimeout = e.newDesc(
+		"scrape_timeout",
+		"Equals 1 if collecting scrape was interrupted by timeout and stats are partial",
+		nil)
+
+	e.metrics.up = e.newDesc(
+		"up",
+		"Equals 1 if the rethinkdb cluster is reachable and stats are queried",
+		nil)
+	e.metrics.collectorSuccess = e.newDesc(
+		"collector_success",
+		"Equals 1 if the collector finished without errors",
+		[]string{"collector"})
+	e.metrics.collectorDurationSeconds = e.newDesc(
+		"collector_duration_seconds",
+		"Duration of the collector in seconds",
+		[]string{"collector"})
+
+	e.metrics.snapshotAgeSeconds = e.newDesc(
+		"snapshot_age_seconds",
+		"Number of seconds since the start of the background collecting of the exported snapshot",
+		nil)
+	e.metrics.snapshotDurationSeconds = e.newDesc(
+		"snapshot_duration_seconds",
+		"Duration of the background collecting of the exported snapshot",
+		nil)
+
+	e.metrics.configLastReloadSuccessful = e.newDesc(
+		"config_last_reload_successful",
+		"Equals 1 if the last config reload succeeded",
+		nil)
+	e.metrics.configLastReloadSuccessTimestamp = e.newDesc(
+		"config_last_reload_success_timestamp_seconds",
+		"Timestamp of the last successful config reload",
+		nil)
+
+	e.metrics.clientCertificateExpiryTimestamp = e.newDesc(
+		"client_certificate_expiry_timestamp_seconds",
+		"Expiration timestamp of the client certificate of the rethinkdb connection",
+		nil)
+
+	e.metrics.sessionCircuitBreakerState = e.newDesc(
+		"session_circuit_breaker_state",
+		"Equals 1 for the current state of the circuit breaker of the rethinkdb connection",
+		[]string{"state"})
+	e.metrics.sessionReconnectsTotal = e.newDesc(
+		"session_reconnects_total",
+		"Total number of reconnects of the rethinkdb connection after connection errors",
+		nil)
+	e.metrics.sessionConnectFailuresTotal = e.newDesc(
+		"session_connect_failures_total",
+		"Total number of failed connect attempts of the rethinkdb connection",
+		nil)
 }
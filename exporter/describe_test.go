@@ -0,0 +1,77 @@
+package exporter
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var fqNameRe = regexp.MustCompile(`fqName: "([^"]+)"`)
+
+// describedNames returns fully-qualified names of the exporter metrics
+func describedNames(e *RethinkdbExporter) map[string]bool {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		e.Describe(ch)
+		close(ch)
+	}()
+	names := make(map[string]bool)
+	for desc := range ch {
+		m := fqNameRe.FindStringSubmatch(desc.String())
+		if m != nil {
+			names[m[1]] = true
+		}
+	}
+	return names
+}
+
+func TestNewConstLabels(t *testing.T) {
+	tests := []struct {
+		name        string
+		constLabels prometheus.Labels
+		wantErr     string
+	}{
+		{name: "valid", constLabels: prometheus.Labels{"cluster": "production"}},
+		{name: "repeats variable label", constLabels: prometheus.Labels{"server": "a"}, wantErr: "const label 'server'"},
+		{name: "repeats table label", constLabels: prometheus.Labels{"table": "a"}, wantErr: "const label 'table'"},
+		{name: "invalid name", constLabels: prometheus.Labels{"bad-label": "a"}, wantErr: "invalid const label name"},
+		{name: "reserved name", constLabels: prometheus.Labels{"__name__": "a"}, wantErr: "invalid const label name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := New("/metrics", "", nil, nil, Options{Namespace: "rethinkdb", ConstLabels: tt.constLabels})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if err := prometheus.NewRegistry().Register(e); err != nil {
+					t.Fatalf("failed to register exporter: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNewLegacyNames(t *testing.T) {
+	e, err := New("/metrics", "", nil, nil, Options{Namespace: "rethinkdb", LegacyNames: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	names := describedNames(e)
+	for _, name := range []string{"cluster_client_connections", "tablereplica_io", "scrape_latency", "rethinkdb_up", "rethinkdb_collector_success"} {
+		if !names[name] {
+			t.Errorf("metric %v is not described", name)
+		}
+	}
+	for _, name := range []string{"up", "rethinkdb_cluster_client_connections"} {
+		if names[name] {
+			t.Errorf("metric %v must not be described", name)
+		}
+	}
+}
@@ -1,106 +1,402 @@
 package exporter
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/version"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/semaphore"
 	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
 )
 
+// ClientCertificate is the client certificate of the rethinkdb connection
+type ClientCertificate interface {
+	// NotAfter returns expiration time of the certificate, false if there is no certificate
+	NotAfter() (time.Time, bool)
+}
+
+// SessionHealth is the reconnect state of the rethinkdb connection.
+// Connections of the exporter and probes implement it.
+type SessionHealth interface {
+	// BreakerState returns the state of the circuit breaker: closed, open or half_open
+	BreakerState() string
+	// Reconnects returns the number of reconnects after connection errors
+	Reconnects() uint64
+	// ConnectFailures returns the number of failed connect attempts
+	ConnectFailures() uint64
+}
+
+// ProbeConnector provides connections to the probed rethinkdb targets
+type ProbeConnector interface {
+	// Connect returns query executor to the target with parameters of the named module
+	Connect(target, module string) (r.QueryExecutor, error)
+	// CustomQueries tells if custom queries run against targets of the named module
+	CustomQueries(module string) bool
+}
+
 // RethinkdbExporter is a prometheus exporter of the rethinkdb statistics
 type RethinkdbExporter struct {
-	rconn r.QueryExecutor
+	rconn  r.QueryExecutor
+	probes ProbeConnector
+
+	opts Options
+
+	tableInfo        *tableInfoCache
+	tableInfoWorkers *semaphore.Weighted
+
+	collectors []collector
+
+	customQueries    []*customQuery
+	customQueryCache *customQueryCache
 
-	collectTableStats bool
+	latencyProbe *latencyProbe
 
-	listenAddress string
-	mux           *http.ServeMux
+	nodeCache *nodeCache
+
+	mux *http.ServeMux
+
+	snapshotMu sync.RWMutex
+	snapshot   *snapshot
+
+	readiness readiness
+
+	// reloadStatus is shared by the exporters swapped by the server on config reload
+	reloadStatus *reloadStatus
+
+	// metricNames and variableLabels of the described metrics, variable labels map to a metric name
+	metricNames    map[string]bool
+	variableLabels map[string]string
 
 	metrics struct {
 		clusterClientConnections *prometheus.Desc
+		clusterClientsActive     *prometheus.Desc
+		clusterQueriesPerSecond  *prometheus.Desc
 		clusterDocsPerSecond     *prometheus.Desc
 
 		serverClientConnections *prometheus.Desc
+		serverClientsActive     *prometheus.Desc
 		serverQueriesPerSecond  *prometheus.Desc
+		serverQueriesTotal      *prometheus.Desc
 		serverDocsPerSecond     *prometheus.Desc
+		serverDocsTotal         *prometheus.Desc
+
+		serverUp               *prometheus.Desc
+		serverUptimeSeconds    *prometheus.Desc
+		serverConnectedSeconds *prometheus.Desc
+		serverCacheSizeBytes   *prometheus.Desc
+		serverInfo             *prometheus.Desc
 
 		tableDocsPerSecond *prometheus.Desc
 		tableRowsCount     *prometheus.Desc
 
-		tableReplicaDocsPerSecond *prometheus.Desc
-		tableReplicaCacheBytes    *prometheus.Desc
-		tableReplicaIO            *prometheus.Desc
-		tableReplicaDataBytes     *prometheus.Desc
+		tableRowsCountAgeSeconds *prometheus.Desc
+
+		tableReadyForOutdatedReads *prometheus.Desc
+		tableReadyForReads         *prometheus.Desc
+		tableReadyForWrites        *prometheus.Desc
+		tableAllReplicasReady      *prometheus.Desc
+		tableShardPrimaryReplicas  *prometheus.Desc
+		tableShardReplicaState     *prometheus.Desc
+
+		currentIssues    *prometheus.Desc
+		currentIssueInfo *prometheus.Desc
+
+		jobsActive                  *prometheus.Desc
+		indexConstructionProgress   *prometheus.Desc
+		backfillProgress            *prometheus.Desc
+		queryLongestDurationSeconds *prometheus.Desc
+
+		tableConfigInfo                   *prometheus.Desc
+		tableConfigShards                 *prometheus.Desc
+		tableShardConfigReplicas          *prometheus.Desc
+		tableShardConfigNonvotingReplicas *prometheus.Desc
+		dbConfigInfo                      *prometheus.Desc
+		serverConfigInfo                  *prometheus.Desc
+		serverConfigCacheSizeBytes        *prometheus.Desc
+
+		nodeUp                     *prometheus.Desc
+		nodeConnectDurationSeconds *prometheus.Desc
+		nodeInfo                   *prometheus.Desc
+		nodePeerConnected          *prometheus.Desc
+
+		tableReplicaDocsPerSecond     *prometheus.Desc
+		tableReplicaDocsTotal         *prometheus.Desc
+		tableReplicaCacheBytes        *prometheus.Desc
+		tableReplicaIO                *prometheus.Desc
+		tableReplicaIOTotal           *prometheus.Desc
+		tableReplicaDataBytes         *prometheus.Desc
+		tableReplicaGarbageBytes      *prometheus.Desc
+		tableReplicaMetadataBytes     *prometheus.Desc
+		tableReplicaPreallocatedBytes *prometheus.Desc
 
 		scrapeLatency *prometheus.Desc
 		scrapeErrors  *prometheus.Desc
+		scrapeTimeout *prometheus.Desc
+
+		up                       *prometheus.Desc
+		collectorSuccess         *prometheus.Desc
+		collectorDurationSeconds *prometheus.Desc
+
+		snapshotAgeSeconds      *prometheus.Desc
+		snapshotDurationSeconds *prometheus.Desc
+
+		configLastReloadSuccessful       *prometheus.Desc
+		configLastReloadSuccessTimestamp *prometheus.Desc
+
+		clientCertificateExpiryTimestamp *prometheus.Desc
+
+		sessionCircuitBreakerState  *prometheus.Desc
+		sessionReconnectsTotal      *prometheus.Desc
+		sessionConnectFailuresTotal *prometheus.Desc
 	}
 }
 
+const (
+	// scrapeTimeoutHeader is set by prometheus to its scrape timeout in seconds
+	scrapeTimeoutHeader = "X-Prometheus-Scrape-Timeout-Seconds"
+	// scrapeTimeoutOffset is reserved from prometheus scrape timeout for sending the response
+	scrapeTimeoutOffset = 500 * time.Millisecond
+)
+
+// paths of the health checks
+const (
+	healthyPath = "/-/healthy"
+	readyPath   = "/-/ready"
+)
+
+// DefaultProbeModule is used for probing when module is not set in the request
+const DefaultProbeModule = "default"
+
+// defaultTableInfoWorkers is used if number of table info workers is not set
+const defaultTableInfoWorkers = 4
+
+// Options defines parameters of collecting stats
+type Options struct {
+	// TableDocsEstimates enables collecting rows count estimates of the tables
+	TableDocsEstimates bool
+	// TableInfoWorkers limits number of concurrent queries of rows count estimates
+	TableInfoWorkers int
+	// TableInfoRefreshInterval is a period of serving cached rows count estimates, zero means refresh on every scrape
+	TableInfoRefreshInterval time.Duration
+	// ScrapeTimeout limits duration of collecting stats, zero means no limit
+	ScrapeTimeout time.Duration
+
+	// DBFilter filters databases by name, nil matches all
+	DBFilter *NameFilter
+	// TableFilter filters tables by full name "db.table", nil matches all
+	TableFilter *NameFilter
+	// ServerFilter filters servers by name, nil matches all
+	ServerFilter *NameFilter
+
+	// Namespace is a prefix of metrics names joined with underscore, empty for unprefixed names
+	Namespace string
+	// LegacyNames exports metrics which existed before the namespace without it, new metrics keep the namespace
+	LegacyNames bool
+	// ConstLabels are added to all exported metrics
+	ConstLabels prometheus.Labels
+
+	// Collectors enables or disables collectors by name, see Collectors for available ones.
+	// Collectors missing in the map are enabled by default.
+	Collectors map[string]bool
+
+	// CustomQueries defines metrics collected with user defined ReQL queries
+	CustomQueries []CustomQuery
+
+	// NodeAddresses are addresses of the servers checked directly by nodes collector.
+	// Servers of the cluster are discovered from server_status if it is empty.
+	NodeAddresses []string
+
+	// ClientCertificate of the exporter connection exports its expiration time, nil if there is no certificate
+	ClientCertificate ClientCertificate
+
+	// LatencyProbe defines synthetic probing of the cluster latency with the exporter connection
+	LatencyProbe LatencyProbeOptions
+}
+
 type promHTTPLogger struct{}
 
 func (l promHTTPLogger) Println(v ...interface{}) {
 	log.Error().Msgf("msg: %v", fmt.Sprint(v...))
 }
 
-// New creates a new instance of prometheus rethinkdb exporter
+// New creates a new instance of prometheus rethinkdb exporter.
+// If probes is not nil, targets can be probed with probePath endpoint.
 func New(
-	listenAddress string,
 	telemetryPath string,
+	probePath string,
 	rconn r.QueryExecutor,
-	collectTableStats bool,
+	probes ProbeConnector,
+	opts Options,
 ) (*RethinkdbExporter, error) {
+	if opts.TableInfoWorkers <= 0 {
+		opts.TableInfoWorkers = defaultTableInfoWorkers
+	}
+
 	exporter := &RethinkdbExporter{
-		listenAddress:     listenAddress,
-		collectTableStats: collectTableStats,
-		rconn:             rconn,
+		opts:             opts,
+		rconn:            rconn,
+		probes:           probes,
+		tableInfo:        newTableInfoCache(),
+		tableInfoWorkers: semaphore.NewWeighted(int64(opts.TableInfoWorkers)),
+		customQueryCache: newCustomQueryCache(),
+		nodeCache:        newNodeCache(),
 	}
 
 	exporter.initMetrics()
-
-	prometheus.MustRegister(exporter)
+	err := exporter.initCollectors()
+	if err != nil {
+		return nil, err
+	}
+	err = exporter.initLatencyProbe()
+	if err != nil {
+		return nil, err
+	}
+	err = exporter.initCustomQueries()
+	if err != nil {
+		return nil, err
+	}
+	err = exporter.validateConstLabels()
+	if err != nil {
+		return nil, err
+	}
+	err = exporter.validateDescs()
+	if err != nil {
+		return nil, err
+	}
 
 	exporter.mux = http.NewServeMux()
 	exporter.mux.Handle(telemetryPath,
 		promhttp.InstrumentMetricHandler(
 			prometheus.DefaultRegisterer,
-			promhttp.HandlerFor(
-				prometheus.DefaultGatherer,
-				promhttp.HandlerOpts{
-					ErrorLog: &promHTTPLogger{},
-				},
-			),
+			http.HandlerFunc(exporter.handleMetrics),
 		),
 	)
+	if probes != nil {
+		exporter.mux.HandleFunc(probePath, exporter.handleProbe)
+	}
+	probeLink := ""
+	if probes != nil {
+		probeLink = `<p><a href='` + probePath + `?target=localhost:28015'>Probe localhost:28015</a></p>`
+	}
 	exporter.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(`<html>
              <head><title>RethinkDB Exporter</title></head>
              <body>
              <h1>RethinkDB Exporter</h1>
              <p><a href='` + telemetryPath + `'>Metrics</a></p>
+             ` + probeLink + `
              <h2>Build</h2>
              <pre>` + version.Info() + ` ` + version.BuildContext() + `</pre>
              </body>
              </html>`))
 	})
-	exporter.mux.HandleFunc("/-/healthy", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = fmt.Fprintf(w, "OK")
-	})
-	exporter.mux.HandleFunc("/-/ready", func(w http.ResponseWriter, r *http.Request) {
+	exporter.mux.HandleFunc(healthyPath, func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = fmt.Fprintf(w, "OK")
 	})
+	exporter.mux.HandleFunc(readyPath, exporter.handleReady)
 
 	return exporter, nil
 }
 
-// ListenAndServe runs prometheus http-server for exporting stats
-func (e *RethinkdbExporter) ListenAndServe() error {
-	serv := http.Server{Addr: e.listenAddress, Handler: e.mux}
-	return serv.ListenAndServe()
+// handleMetrics gathers default registry metrics and rethinkdb stats collected within the scrape timeout
+func (e *RethinkdbExporter) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	ctx, cancel := withScrapeTimeout(req.Context(), e.requestScrapeTimeout(req))
+	defer cancel()
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&scrapeCollector{ctx: ctx, e: e, rconn: e.rconn, main: true})
+
+	promhttp.HandlerFor(
+		prometheus.Gatherers{prometheus.DefaultGatherer, reg},
+		promhttp.HandlerOpts{
+			ErrorLog: &promHTTPLogger{},
+		},
+	).ServeHTTP(w, req)
+}
+
+// handleProbe collects rethinkdb stats of the target from request params into a separate registry
+func (e *RethinkdbExporter) handleProbe(w http.ResponseWriter, req *http.Request) {
+	params := req.URL.Query()
+	target := params.Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	module := params.Get("module")
+	if module == "" {
+		module = DefaultProbeModule
+	}
+
+	rconn, err := e.probes.Connect(target, module)
+	if err != nil {
+		log.Warn().Err(err).Str("target", target).Str("module", module).Msg("failed to prepare probe")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := withScrapeTimeout(req.Context(), e.requestScrapeTimeout(req))
+	defer cancel()
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&scrapeCollector{ctx: ctx, e: e, rconn: rconn, customQueries: e.probes.CustomQueries(module)})
+
+	promhttp.HandlerFor(
+		reg,
+		promhttp.HandlerOpts{
+			ErrorLog: &promHTTPLogger{},
+		},
+	).ServeHTTP(w, req)
+}
+
+// requestScrapeTimeout returns the least of configured timeout and prometheus scrape timeout from request header
+func (e *RethinkdbExporter) requestScrapeTimeout(req *http.Request) time.Duration {
+	timeout := e.opts.ScrapeTimeout
+
+	header := req.Header.Get(scrapeTimeoutHeader)
+	if header == "" {
+		return timeout
+	}
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil {
+		log.Warn().Err(err).Str("header", header).Msg("failed to parse scrape timeout header")
+		return timeout
+	}
+
+	promTimeout := time.Duration(seconds*float64(time.Second)) - scrapeTimeoutOffset
+	if promTimeout > 0 && (timeout <= 0 || promTimeout < timeout) {
+		timeout = promTimeout
+	}
+	return timeout
+}
+
+// scrapeCollector collects exporter metrics with the context of a single scrape request
+type scrapeCollector struct {
+	ctx   context.Context
+	e     *RethinkdbExporter
+	rconn r.QueryExecutor
+	// main marks the exporter connection, it sends the background snapshot if there is one and latency probe metrics
+	main bool
+	// customQueries enables custom queries against the probed target
+	customQueries bool
+}
+
+func (c *scrapeCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.e.Describe(ch)
+}
+
+func (c *scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.main {
+		c.e.collectMain(c.ctx, ch)
+		return
+	}
+	c.e.collect(c.ctx, c.rconn, c.customQueries, ch)
+	c.e.sendSessionHealth(c.rconn, ch)
 }
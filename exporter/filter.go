@@ -0,0 +1,72 @@
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// NameFilter matches names with include and exclude regexps.
+// Regexps are anchored, empty include list matches all names, exclude takes precedence.
+// Nil filter matches all names.
+type NameFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// NewNameFilter compiles include and exclude regexps into a filter
+func NewNameFilter(include, exclude []string) (*NameFilter, error) {
+	var err error
+	f := &NameFilter{}
+	f.include, err = compileAnchored(include)
+	if err != nil {
+		return nil, err
+	}
+	f.exclude, err = compileAnchored(exclude)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Match returns true if the name passes the filter
+func (f *NameFilter) Match(name string) bool {
+	if f == nil {
+		return true
+	}
+	for _, re := range f.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func compileAnchored(exprs []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter regexp '%v': %v", expr, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// tableAllowed checks the db and the full table name "db.table" with filters
+func (e *RethinkdbExporter) tableAllowed(db, table string) bool {
+	return e.opts.DBFilter.Match(db) && e.opts.TableFilter.Match(db+"."+table)
+}
+
+// serverAllowed checks the server name with the filter
+func (e *RethinkdbExporter) serverAllowed(server string) bool {
+	return e.opts.ServerFilter.Match(server)
+}
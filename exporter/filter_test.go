@@ -0,0 +1,72 @@
+package exporter
+
+import "testing"
+
+func TestNameFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		matches map[string]bool
+	}{
+		{
+			name:    "empty matches all",
+			matches: map[string]bool{"app": true, "": true},
+		},
+		{
+			name:    "include is anchored",
+			include: []string{"app"},
+			matches: map[string]bool{"app": true, "app2": false, "myapp": false},
+		},
+		{
+			name:    "include alternatives",
+			include: []string{"app|test_.*"},
+			matches: map[string]bool{"app": true, "test_1": true, "prod": false},
+		},
+		{
+			name:    "exclude takes precedence",
+			include: []string{"app.*"},
+			exclude: []string{"app_tmp"},
+			matches: map[string]bool{"app": true, "app_tmp": false, "other": false},
+		},
+		{
+			name:    "exclude only",
+			exclude: []string{"rethinkdb", "tmp_.*"},
+			matches: map[string]bool{"app": true, "rethinkdb": false, "tmp_1": false},
+		},
+		{
+			name:    "full table name",
+			include: []string{`app\.users`},
+			matches: map[string]bool{"app.users": true, "app_users": false, "app.users2": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewNameFilter(tt.include, tt.exclude)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for name, want := range tt.matches {
+				if got := f.Match(name); got != want {
+					t.Errorf("Match(%q) = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNameFilterNil(t *testing.T) {
+	var f *NameFilter
+	if !f.Match("anything") {
+		t.Error("nil filter must match all names")
+	}
+}
+
+func TestNameFilterInvalidRegexp(t *testing.T) {
+	if _, err := NewNameFilter([]string{"("}, nil); err == nil {
+		t.Error("expected error for invalid include regexp")
+	}
+	if _, err := NewNameFilter(nil, []string{"[a-"}); err == nil {
+		t.Error("expected error for invalid exclude regexp")
+	}
+}
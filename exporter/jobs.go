@@ -0,0 +1,104 @@
+package exporter
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+const (
+	queryJob             = "query"
+	indexConstructionJob = "index_construction"
+	backfillJob          = "backfill"
+)
+
+func init() {
+	registerCollector("jobs", true, (*RethinkdbExporter).collectJobs)
+}
+
+type job struct {
+	Type        string   `rethinkdb:"type"`
+	Servers     []string `rethinkdb:"servers"`
+	DurationSec float64  `rethinkdb:"duration_sec"`
+	Info        struct {
+		DB                string  `rethinkdb:"db"`
+		Table             string  `rethinkdb:"table"`
+		Index             string  `rethinkdb:"index"`
+		DestinationServer string  `rethinkdb:"destination_server"`
+		Progress          float64 `rethinkdb:"progress"`
+	} `rethinkdb:"info"`
+}
+
+type jobKey struct {
+	Type   string
+	Server string
+}
+
+type indexKey struct {
+	DB    string
+	Table string
+	Index string
+}
+
+type backfillKey struct {
+	DB                string
+	Table             string
+	DestinationServer string
+}
+
+// collectJobs exports long-running jobs from jobs system table.
+// Progress of the job running on several servers is the least one.
+func (e *RethinkdbExporter) collectJobs(ctx context.Context, rconn r.QueryExecutor, ch chan<- prometheus.Metric) int {
+	var jobs []job
+	err := r.DB(r.SystemDatabase).Table(r.JobsSystemTable).ReadAll(&jobs, rconn, r.RunOpts{Context: ctx})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to query jobs table")
+		return 1
+	}
+
+	active := make(map[jobKey]int)
+	indexes := make(map[indexKey]float64)
+	backfills := make(map[backfillKey]float64)
+	longestQuery := 0.0
+	for _, job := range jobs {
+		for _, server := range job.Servers {
+			if e.serverAllowed(server) {
+				active[jobKey{Type: job.Type, Server: server}]++
+			}
+		}
+		if job.Info.Table != "" && !e.tableAllowed(job.Info.DB, job.Info.Table) {
+			continue
+		}
+
+		switch job.Type {
+		case queryJob:
+			if job.DurationSec > longestQuery {
+				longestQuery = job.DurationSec
+			}
+		case indexConstructionJob:
+			key := indexKey{DB: job.Info.DB, Table: job.Info.Table, Index: job.Info.Index}
+			if progress, ok := indexes[key]; !ok || job.Info.Progress < progress {
+				indexes[key] = job.Info.Progress
+			}
+		case backfillJob:
+			key := backfillKey{DB: job.Info.DB, Table: job.Info.Table, DestinationServer: job.Info.DestinationServer}
+			if progress, ok := backfills[key]; !ok || job.Info.Progress < progress {
+				backfills[key] = job.Info.Progress
+			}
+		}
+	}
+
+	for key, count := range active {
+		ch <- prometheus.MustNewConstMetric(e.metrics.jobsActive, prometheus.GaugeValue, float64(count), key.Type, key.Server)
+	}
+	for key, progress := range indexes {
+		ch <- prometheus.MustNewConstMetric(e.metrics.indexConstructionProgress, prometheus.GaugeValue, progress, key.DB, key.Table, key.Index)
+	}
+	for key, progress := range backfills {
+		ch <- prometheus.MustNewConstMetric(e.metrics.backfillProgress, prometheus.GaugeValue, progress, key.DB, key.Table, key.DestinationServer)
+	}
+	ch <- prometheus.MustNewConstMetric(e.metrics.queryLongestDurationSeconds, prometheus.GaugeValue, longestQuery)
+	return 0
+}
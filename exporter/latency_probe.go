@@ -0,0 +1,223 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+// operations of the latency probe
+const (
+	prepareOperation = "prepare"
+	insertOperation  = "insert"
+	getOperation     = "get"
+	updateOperation  = "update"
+	deleteOperation  = "delete"
+)
+
+// LatencyProbeOptions defines synthetic probing of read and write latency
+type LatencyProbeOptions struct {
+	// Enabled starts probing with RunLatencyProbe
+	Enabled bool
+	// Interval between probes
+	Interval time.Duration
+	// DB is a database of the probe table
+	DB string
+	// Table is a probe table, probe document is written to it and deleted
+	Table string
+	// CreateTable creates the database and the table if they don't exist
+	CreateTable bool
+	// Durability of the writes: hard or soft
+	Durability string
+	// ReadMode of the reads: single, majority or outdated
+	ReadMode string
+}
+
+// latencyProbe keeps latency histograms of the probe operations
+type latencyProbe struct {
+	opts LatencyProbeOptions
+	// docID is unique for the exporter instance to not interfere with other exporters
+	docID string
+	// ready is set when the probe table exists
+	ready bool
+
+	latency *prometheus.HistogramVec
+	errors  *prometheus.CounterVec
+}
+
+// initLatencyProbe validates options of the latency probe and makes its metrics
+func (e *RethinkdbExporter) initLatencyProbe() error {
+	opts := e.opts.LatencyProbe
+	if !opts.Enabled {
+		return nil
+	}
+
+	if opts.Interval <= 0 {
+		return errors.New("latency probe interval must be positive")
+	}
+	if opts.DB == "" || opts.Table == "" {
+		return errors.New("latency probe db and table must be set")
+	}
+	switch opts.Durability {
+	case "hard", "soft":
+	default:
+		return fmt.Errorf("unsupported latency probe durability '%v'", opts.Durability)
+	}
+	switch opts.ReadMode {
+	case "single", "majority", "outdated":
+	default:
+		return fmt.Errorf("unsupported latency probe read mode '%v'", opts.ReadMode)
+	}
+
+	e.registerVariableLabels(prometheus.BuildFQName(e.opts.Namespace, "", "latency_probe_duration_seconds"), []string{"operation", "durability", "read_mode"})
+	e.registerVariableLabels(prometheus.BuildFQName(e.opts.Namespace, "", "latency_probe_errors_total"), []string{"operation"})
+
+	hostname, _ := os.Hostname()
+	e.latencyProbe = &latencyProbe{
+		opts:  opts,
+		docID: fmt.Sprintf("%v-%v", hostname, os.Getpid()),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   e.opts.Namespace,
+			Name:        "latency_probe_duration_seconds",
+			Help:        "Latency of the probe operations on the probe table",
+			ConstLabels: e.opts.ConstLabels,
+			Buckets:     prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"operation", "durability", "read_mode"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   e.opts.Namespace,
+			Name:        "latency_probe_errors_total",
+			Help:        "Number of failed probe operations",
+			ConstLabels: e.opts.ConstLabels,
+		}, []string{"operation"}),
+	}
+	return nil
+}
+
+// RunLatencyProbe runs insert, get, update and delete of the probe document every interval until ctx is done.
+// Latencies are exported with the metrics of the exporter connection, not with probed targets.
+func (e *RethinkdbExporter) RunLatencyProbe(ctx context.Context) {
+	if e.latencyProbe == nil {
+		return
+	}
+
+	ticker := time.NewTicker(e.latencyProbe.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		e.latencyProbe.probe(ctx, e.rconn)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *latencyProbe) probe(ctx context.Context, rconn r.QueryExecutor) {
+	ctx, cancel := context.WithTimeout(ctx, p.opts.Interval)
+	defer cancel()
+
+	if !p.ready {
+		err := p.prepare(ctx, rconn)
+		if err != nil {
+			log.WithLevel(queryErrorLevel(rconn, zerolog.WarnLevel)).Err(err).Str("db", p.opts.DB).Str("table", p.opts.Table).Msg("failed to prepare latency probe table")
+			p.errors.WithLabelValues(prepareOperation).Inc()
+			return
+		}
+		p.ready = true
+	}
+
+	table := r.DB(p.opts.DB).Table(p.opts.Table, r.TableOpts{ReadMode: p.opts.ReadMode})
+	operations := []struct {
+		name string
+		run  func() error
+	}{
+		{insertOperation, func() error {
+			doc := map[string]interface{}{"id": p.docID, "time": r.Now()}
+			_, err := table.Insert(doc, r.InsertOpts{Durability: p.opts.Durability, Conflict: "replace"}).RunWrite(rconn, r.RunOpts{Context: ctx})
+			return err
+		}},
+		{getOperation, func() error {
+			var doc map[string]interface{}
+			return table.Get(p.docID).ReadOne(&doc, rconn, r.RunOpts{Context: ctx})
+		}},
+		{updateOperation, func() error {
+			doc := map[string]interface{}{"time": r.Now()}
+			_, err := table.Get(p.docID).Update(doc, r.UpdateOpts{Durability: p.opts.Durability}).RunWrite(rconn, r.RunOpts{Context: ctx})
+			return err
+		}},
+		{deleteOperation, func() error {
+			_, err := table.Get(p.docID).Delete(r.DeleteOpts{Durability: p.opts.Durability}).RunWrite(rconn, r.RunOpts{Context: ctx})
+			return err
+		}},
+	}
+
+	for _, op := range operations {
+		start := time.Now()
+		err := op.run()
+		if err != nil {
+			log.WithLevel(queryErrorLevel(rconn, zerolog.WarnLevel)).Err(err).Str("operation", op.name).Msg("latency probe operation failed")
+			p.errors.WithLabelValues(op.name).Inc()
+			return
+		}
+		p.latency.WithLabelValues(op.name, p.opts.Durability, p.opts.ReadMode).Observe(time.Since(start).Seconds())
+	}
+}
+
+// prepare checks the probe table exists and creates it if it is allowed
+func (p *latencyProbe) prepare(ctx context.Context, rconn r.QueryExecutor) error {
+	if !p.opts.CreateTable {
+		return r.DB(p.opts.DB).Table(p.opts.Table).Info().Exec(rconn, r.ExecOpts{Context: ctx})
+	}
+
+	var exists bool
+	err := r.DBList().Contains(p.opts.DB).ReadOne(&exists, rconn, r.RunOpts{Context: ctx})
+	if err != nil {
+		return err
+	}
+	if !exists {
+		err = r.DBCreate(p.opts.DB).Exec(rconn, r.ExecOpts{Context: ctx})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = r.DB(p.opts.DB).TableList().Contains(p.opts.Table).ReadOne(&exists, rconn, r.RunOpts{Context: ctx})
+	if err != nil {
+		return err
+	}
+	if !exists {
+		err = r.DB(p.opts.DB).TableCreate(p.opts.Table).Exec(rconn, r.ExecOpts{Context: ctx})
+		if err != nil {
+			return err
+		}
+		err = r.DB(p.opts.DB).Table(p.opts.Table).Wait().Exec(rconn, r.ExecOpts{Context: ctx})
+	}
+	return err
+}
+
+// sendLatencyProbe sends latency histograms if the probe is enabled
+func (e *RethinkdbExporter) sendLatencyProbe(ch chan<- prometheus.Metric) {
+	if e.latencyProbe == nil {
+		return
+	}
+	e.latencyProbe.latency.Collect(ch)
+	e.latencyProbe.errors.Collect(ch)
+}
+
+// describeLatencyProbe sends descriptions of latency histograms if the probe is enabled
+func (e *RethinkdbExporter) describeLatencyProbe(ch chan<- *prometheus.Desc) {
+	if e.latencyProbe == nil {
+		return
+	}
+	e.latencyProbe.latency.Describe(ch)
+	e.latencyProbe.errors.Describe(ch)
+}
@@ -0,0 +1,262 @@
+package exporter
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+func init() {
+	registerCollector("nodes", false, (*RethinkdbExporter).collectNodes)
+}
+
+// NodeDialer opens direct sessions to the nodes of the cluster.
+// Connections of the exporter and probes implement it with their credentials.
+type NodeDialer interface {
+	// DialNode opens a new session to the single node address, caller must close it
+	DialNode(ctx context.Context, address string) (*r.Session, error)
+}
+
+// nodeCacheExpiration is duration after that servers of the connection not collected anymore are forgotten
+const nodeCacheExpiration = 10 * time.Minute
+
+// nodeTarget is a node checked by the collector, address is the label and candidates are dialed in order
+type nodeTarget struct {
+	address    string
+	candidates []string
+}
+
+// knownNode is a server discovered from server_status, it is checked until it is removed from server_config
+type knownNode struct {
+	name      string
+	addresses []string
+}
+
+type connectionNodes struct {
+	servers map[string]knownNode
+	used    time.Time
+}
+
+// nodeCache keeps discovered servers of every connection by server id,
+// so servers which disappeared from server_status are reported down instead of missing
+type nodeCache struct {
+	m           sync.Mutex
+	connections map[r.QueryExecutor]*connectionNodes
+}
+
+func newNodeCache() *nodeCache {
+	return &nodeCache{
+		connections: make(map[r.QueryExecutor]*connectionNodes),
+	}
+}
+
+// update adds reachable servers, removes servers missing in the config and returns all known servers of the connection
+func (c *nodeCache) update(rconn r.QueryExecutor, statuses []serverStatus, configured map[string]bool) map[string]knownNode {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	now := time.Now()
+	for key, conn := range c.connections {
+		if now.Sub(conn.used) >= nodeCacheExpiration {
+			delete(c.connections, key)
+		}
+	}
+
+	conn, ok := c.connections[rconn]
+	if !ok {
+		conn = &connectionNodes{servers: make(map[string]knownNode)}
+		c.connections[rconn] = conn
+	}
+	conn.used = now
+
+	for _, status := range statuses {
+		conn.servers[status.ID] = knownNode{name: status.Name, addresses: nodeAddresses(status)}
+	}
+	for id := range conn.servers {
+		if !configured[id] {
+			delete(conn.servers, id)
+		}
+	}
+
+	res := make(map[string]knownNode, len(conn.servers))
+	for id, node := range conn.servers {
+		res[id] = node
+	}
+	return res
+}
+
+// nodeAddresses returns reql addresses of the server: canonical addresses, the hostname and loopback canonical addresses last.
+// Canonical addresses are reachable by other servers even if the hostname does not resolve.
+func nodeAddresses(status serverStatus) []string {
+	port := strconv.Itoa(status.Network.ReqlPort)
+	var addresses, loopback []string
+	seen := make(map[string]bool)
+	add := func(list *[]string, host string) {
+		if host == "" {
+			return
+		}
+		address := net.JoinHostPort(host, port)
+		if seen[address] {
+			return
+		}
+		seen[address] = true
+		*list = append(*list, address)
+	}
+
+	for _, canonical := range status.Network.CanonicalAddresses {
+		if ip := net.ParseIP(canonical.Host); ip != nil && ip.IsLoopback() {
+			add(&loopback, canonical.Host)
+			continue
+		}
+		add(&addresses, canonical.Host)
+	}
+	add(&addresses, status.Network.Hostname)
+	return append(addresses, loopback...)
+}
+
+// nodeResult is the view of the cluster from a single node
+type nodeResult struct {
+	address  string
+	up       bool
+	duration time.Duration
+	server   string
+	peers    map[string]bool
+}
+
+// collectNodes connects to every node of the cluster directly and exports its reachability,
+// connect latency and peers the node is connected to. It helps to find partitions
+// which are hidden behind the pool of the shared session.
+func (e *RethinkdbExporter) collectNodes(ctx context.Context, rconn r.QueryExecutor, ch chan<- prometheus.Metric) int {
+	dialer, ok := rconn.(NodeDialer)
+	if !ok {
+		log.Warn().Msg("connection does not support direct node sessions")
+		return 1
+	}
+
+	// configured addresses belong to the exporter cluster, probed targets are always discovered
+	var targets []nodeTarget
+	if len(e.opts.NodeAddresses) != 0 && rconn == e.rconn {
+		for _, address := range e.opts.NodeAddresses {
+			targets = append(targets, nodeTarget{address: address, candidates: []string{address}})
+		}
+	} else {
+		var err error
+		targets, err = e.discoverNodes(ctx, rconn)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to discover nodes from server status and config tables")
+			return 1
+		}
+	}
+
+	results := make([]nodeResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target nodeTarget) {
+			defer wg.Done()
+			results[i] = checkNode(ctx, dialer, target)
+		}(i, target)
+	}
+	wg.Wait()
+
+	for _, res := range results {
+		ch <- prometheus.MustNewConstMetric(e.metrics.nodeUp, prometheus.GaugeValue, boolToFloat(res.up), res.address)
+		if !res.up {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(e.metrics.nodeConnectDurationSeconds, prometheus.GaugeValue, res.duration.Seconds(), res.address)
+		ch <- prometheus.MustNewConstMetric(e.metrics.nodeInfo, prometheus.GaugeValue, 1, res.address, res.server)
+		for peer, connected := range res.peers {
+			if e.serverAllowed(peer) {
+				ch <- prometheus.MustNewConstMetric(e.metrics.nodePeerConnected, prometheus.GaugeValue, boolToFloat(connected), res.address, res.server, peer)
+			}
+		}
+	}
+	return 0
+}
+
+// discoverNodes returns nodes of the servers from server_status system table sorted by address.
+// Servers seen before are kept until they are removed from server_config, so unreachable ones are reported down.
+func (e *RethinkdbExporter) discoverNodes(ctx context.Context, rconn r.QueryExecutor) ([]nodeTarget, error) {
+	var statuses []serverStatus
+	err := r.DB(r.SystemDatabase).Table(r.ServerStatusSystemTable).ReadAll(&statuses, rconn, r.RunOpts{Context: ctx})
+	if err != nil {
+		return nil, err
+	}
+	var configs []serverConfig
+	err = r.DB(r.SystemDatabase).Table(r.ServerConfigSystemTable).ReadAll(&configs, rconn, r.RunOpts{Context: ctx})
+	if err != nil {
+		return nil, err
+	}
+	configured := make(map[string]bool, len(configs))
+	for _, config := range configs {
+		configured[config.ID] = true
+	}
+
+	servers := e.nodeCache.update(rconn, statuses, configured)
+
+	targets := make([]nodeTarget, 0, len(servers))
+	for _, server := range servers {
+		if !e.serverAllowed(server.name) || len(server.addresses) == 0 {
+			continue
+		}
+		targets = append(targets, nodeTarget{address: server.addresses[0], candidates: server.addresses})
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].address < targets[j].address
+	})
+	return targets, nil
+}
+
+// checkNode connects to the first reachable address of the node
+// and reads its own row of server_status with the peers it is connected to
+func checkNode(ctx context.Context, dialer NodeDialer, target nodeTarget) nodeResult {
+	res := nodeResult{address: target.address}
+	address := target.address
+
+	var sess *r.Session
+	var err error
+	for _, address = range target.candidates {
+		start := time.Now()
+		sess, err = dialer.DialNode(ctx, address)
+		if err == nil {
+			res.duration = time.Since(start)
+			break
+		}
+		log.Debug().Err(err).Str("address", address).Msg("failed to connect to node address")
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("address", target.address).Msg("failed to connect to node")
+		return res
+	}
+	defer func() {
+		if err := sess.Close(); err != nil {
+			log.Warn().Err(err).Str("address", address).Msg("failed to close node session")
+		}
+	}()
+
+	server, err := sess.Server()
+	if err != nil {
+		log.Warn().Err(err).Str("address", address).Msg("failed to get server of node")
+		return res
+	}
+
+	var status serverStatus
+	err = r.DB(r.SystemDatabase).Table(r.ServerStatusSystemTable).Get(server.ID).ReadOne(&status, sess, r.RunOpts{Context: ctx})
+	if err != nil {
+		log.Warn().Err(err).Str("address", address).Msg("failed to query server status of node")
+		return res
+	}
+
+	res.up = true
+	res.server = server.Name
+	res.peers = status.Network.ConnectedTo
+	return res
+}
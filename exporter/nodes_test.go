@@ -0,0 +1,78 @@
+package exporter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newTestServerStatus(id, hostname string, hosts ...string) serverStatus {
+	var status serverStatus
+	status.ID = id
+	status.Name = id
+	status.Network.Hostname = hostname
+	status.Network.ReqlPort = 28015
+	for _, host := range hosts {
+		status.Network.CanonicalAddresses = append(status.Network.CanonicalAddresses, canonicalAddress{Host: host, Port: 29015})
+	}
+	return status
+}
+
+func TestNodeAddresses(t *testing.T) {
+	tests := []struct {
+		name   string
+		status serverStatus
+		want   []string
+	}{
+		{
+			name:   "canonical first",
+			status: newTestServerStatus("a", "host-a", "10.0.0.1", "fd00::1"),
+			want:   []string{"10.0.0.1:28015", "[fd00::1]:28015", "host-a:28015"},
+		},
+		{
+			name:   "loopback last",
+			status: newTestServerStatus("a", "host-a", "127.0.0.1", "::1", "10.0.0.1"),
+			want:   []string{"10.0.0.1:28015", "host-a:28015", "127.0.0.1:28015", "[::1]:28015"},
+		},
+		{
+			name:   "duplicates removed",
+			status: newTestServerStatus("a", "host-a", "host-a", "10.0.0.1", "10.0.0.1"),
+			want:   []string{"host-a:28015", "10.0.0.1:28015"},
+		},
+		{
+			name:   "hostname only",
+			status: newTestServerStatus("a", "host-a"),
+			want:   []string{"host-a:28015"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := nodeAddresses(tt.status)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("nodeAddresses() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodeCacheUpdate(t *testing.T) {
+	c := newNodeCache()
+	a := newTestServerStatus("a", "host-a", "10.0.0.1")
+	b := newTestServerStatus("b", "host-b", "10.0.0.2")
+
+	servers := c.update(nil, []serverStatus{a, b}, map[string]bool{"a": true, "b": true})
+	if len(servers) != 2 {
+		t.Fatalf("update() returned %d servers, want 2", len(servers))
+	}
+
+	// b is disconnected but still configured
+	servers = c.update(nil, []serverStatus{a}, map[string]bool{"a": true, "b": true})
+	if _, ok := servers["b"]; !ok || len(servers) != 2 {
+		t.Fatalf("update() = %v, want disconnected server b kept", servers)
+	}
+
+	// b is removed from server_config
+	servers = c.update(nil, []serverStatus{a}, map[string]bool{"a": true})
+	if _, ok := servers["b"]; ok || len(servers) != 1 {
+		t.Fatalf("update() = %v, want removed server b forgotten", servers)
+	}
+}
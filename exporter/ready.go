@@ -0,0 +1,130 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+// readyCacheDuration is duration of caching the connectivity check to not query the cluster on every readiness probe
+const readyCacheDuration = 5 * time.Second
+
+// readyCheckTimeout limits waiting for the connectivity check, the cluster is considered unreachable if it takes longer
+const readyCheckTimeout = 5 * time.Second
+
+// readiness keeps the connectivity check and the result of the last collection with the exporter connection
+type readiness struct {
+	m sync.Mutex
+
+	checked   time.Time
+	connected bool
+	// checking is closed when the running connectivity check is done, nil if no check is running
+	checking chan struct{}
+
+	collected   time.Time
+	collectedUp bool
+}
+
+type readyResponse struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// recordCollect saves the result of the collection, it is called only for the exporter connection
+func (e *RethinkdbExporter) recordCollect(up bool) {
+	e.readiness.m.Lock()
+	defer e.readiness.m.Unlock()
+
+	e.readiness.collected = time.Now()
+	e.readiness.collectedUp = up
+}
+
+// notReadyReason returns why the exporter is not ready or empty string if it is ready
+func (e *RethinkdbExporter) notReadyReason() string {
+	connected := e.checkConnected()
+
+	e.readiness.m.Lock()
+	collected, collectedUp := e.readiness.collected, e.readiness.collectedUp
+	e.readiness.m.Unlock()
+
+	switch {
+	case !connected:
+		return "rethinkdb cluster is unreachable"
+	case !collected.IsZero() && !collectedUp:
+		return "last collection failed to query stats"
+	default:
+		return ""
+	}
+}
+
+// checkConnected returns the cached connectivity or runs the check without holding the lock.
+// Concurrent probes share the running check and wait for it no longer than readyCheckTimeout.
+func (e *RethinkdbExporter) checkConnected() bool {
+	e.readiness.m.Lock()
+	if time.Since(e.readiness.checked) < readyCacheDuration {
+		connected := e.readiness.connected
+		e.readiness.m.Unlock()
+		return connected
+	}
+	done := e.readiness.checking
+	if done == nil {
+		done = make(chan struct{})
+		e.readiness.checking = done
+		go e.runConnectivityCheck(done)
+	}
+	e.readiness.m.Unlock()
+
+	timer := time.NewTimer(readyCheckTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		return false
+	}
+
+	e.readiness.m.Lock()
+	defer e.readiness.m.Unlock()
+	return e.readiness.connected
+}
+
+// runConnectivityCheck runs a trivial query within readyCheckTimeout and saves the result.
+// The session connects if needed, it fails fast while its circuit breaker is open.
+func (e *RethinkdbExporter) runConnectivityCheck(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), readyCheckTimeout)
+	defer cancel()
+
+	err := r.Expr(1).Exec(e.rconn, r.ExecOpts{Context: ctx})
+	if err != nil {
+		log.Debug().Err(err).Msg("readiness connectivity check failed")
+	}
+	connected := err == nil
+
+	e.readiness.m.Lock()
+	e.readiness.connected = connected
+	e.readiness.checked = time.Now()
+	e.readiness.checking = nil
+	e.readiness.m.Unlock()
+	close(done)
+}
+
+// handleReady responds 503 with the reason if the cluster is unreachable or the last collection failed
+func (e *RethinkdbExporter) handleReady(w http.ResponseWriter, req *http.Request) {
+	resp := readyResponse{Status: "ready"}
+	code := http.StatusOK
+	if reason := e.notReadyReason(); reason != "" {
+		resp = readyResponse{Status: "not ready", Reason: reason}
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to write readiness response")
+	}
+}
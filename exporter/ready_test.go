@@ -0,0 +1,65 @@
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+// blockingConn is the connection which queries block until release is closed, the mock only builds queries
+type blockingConn struct {
+	*r.Mock
+	release chan struct{}
+}
+
+func (c *blockingConn) IsConnected() bool {
+	return true
+}
+
+func (c *blockingConn) Exec(ctx context.Context, q r.Query) error {
+	select {
+	case <-c.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestHandleReadyDoesNotBlockCollect(t *testing.T) {
+	conn := &blockingConn{Mock: r.NewMock(), release: make(chan struct{})}
+	e := &RethinkdbExporter{rconn: conn}
+
+	responded := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		e.handleReady(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		responded <- rec.Code
+	}()
+
+	recorded := make(chan struct{})
+	go func() {
+		e.recordCollect(true)
+		close(recorded)
+	}()
+	select {
+	case <-recorded:
+	case <-time.After(time.Second):
+		t.Fatal("recordCollect is blocked by the running connectivity check")
+	}
+
+	close(conn.release)
+	if code := <-responded; code != http.StatusOK {
+		t.Fatalf("status = %v, want %v", code, http.StatusOK)
+	}
+
+	e.recordCollect(false)
+	rec := httptest.NewRecorder()
+	e.handleReady(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status after failed collection = %v, want %v", rec.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,206 @@
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rethinkdb/prometheus-exporter/web"
+	"github.com/rs/zerolog/log"
+)
+
+// reloadPath is the endpoint triggering config reload with POST
+const reloadPath = "/-/reload"
+
+// ReloadFunc re-reads the config and swaps the exporter of the server, the current one must be kept on error
+type ReloadFunc func() error
+
+// Server is the http-server of the exporter.
+// It serves the current exporter, which is swapped atomically when the config is reloaded.
+type Server struct {
+	listenAddress string
+	webConfigFile string
+	reload        ReloadFunc
+
+	exporter atomic.Value // *RethinkdbExporter
+	reloadMu sync.Mutex
+	status   *reloadStatus
+}
+
+// reloadStatus is the result of the last config reload
+type reloadStatus struct {
+	m           sync.Mutex
+	success     bool
+	successTime time.Time
+}
+
+func (s *reloadStatus) set(success bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.success = success
+	if success {
+		s.successTime = time.Now()
+	}
+}
+
+func (s *reloadStatus) get() (bool, time.Time) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.success, s.successTime
+}
+
+// NewServer creates http-server of the exporter.
+// Web config file enables TLS and basic auth, it is plain http if the path is empty.
+func NewServer(listenAddress, webConfigFile string, exp *RethinkdbExporter, reload ReloadFunc) *Server {
+	s := &Server{
+		listenAddress: listenAddress,
+		webConfigFile: webConfigFile,
+		reload:        reload,
+		status:        &reloadStatus{},
+	}
+	s.status.set(true)
+	exp.reloadStatus = s.status
+	s.exporter.Store(exp)
+	return s
+}
+
+// Exporter returns the current exporter
+func (s *Server) Exporter() *RethinkdbExporter {
+	return s.exporter.Load().(*RethinkdbExporter)
+}
+
+// Swap replaces the current exporter, new requests are served by the new one.
+// The metric descriptions are validated before the swap, the current exporter is kept if they are invalid.
+// Caches of the current exporter are kept if they are valid for the new one.
+func (s *Server) Swap(exp *RethinkdbExporter) error {
+	err := exp.validateDescs()
+	if err != nil {
+		return err
+	}
+	exp.reloadStatus = s.status
+	exp.inheritCaches(s.Exporter())
+	s.exporter.Store(exp)
+	return nil
+}
+
+// Reload runs the reload function and updates the reload status, concurrent reloads are serialized
+func (s *Server) Reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	err := s.reload()
+	s.status.set(err == nil)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to reload config, keeping the current one")
+		return err
+	}
+	log.Info().Msg("config reloaded")
+	return nil
+}
+
+// ServeHTTP serves reload endpoint and handlers of the current exporter
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == reloadPath {
+		s.handleReload(w, req)
+		return
+	}
+	s.Exporter().mux.ServeHTTP(w, req)
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	err := s.Reload()
+	if err != nil {
+		http.Error(w, "failed to reload config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write([]byte("OK"))
+}
+
+// ListenAndServe runs prometheus http-server for exporting stats until ctx is done.
+// On shutdown in-flight requests are finished within the grace period, then their collections are cancelled.
+// Health checks don't require basic auth of the web config.
+func (s *Server) ListenAndServe(ctx context.Context, gracePeriod time.Duration) error {
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
+	serv := &http.Server{
+		Addr:    s.listenAddress,
+		Handler: cancelRequestsWith(requestsCtx, s),
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- web.ListenAndServe(serv, s.webConfigFile, healthyPath, readyPath)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	err := serv.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Warn().Err(err).Msg("grace period expired, cancelling in-flight requests")
+		cancelRequests()
+		_ = serv.Close()
+	}
+
+	err = <-errCh
+	if err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// cancelRequestsWith cancels contexts of the requests when ctx is done
+func cancelRequestsWith(ctx context.Context, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		reqCtx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-ctx.Done():
+				cancel()
+			case <-reqCtx.Done():
+			}
+		}()
+		next.ServeHTTP(w, req.WithContext(reqCtx))
+	})
+}
+
+// inheritCaches takes caches of the previous exporter.
+// Table info and nodes are cached by connection, custom query results are kept only if the queries and their names are the same.
+func (e *RethinkdbExporter) inheritCaches(prev *RethinkdbExporter) {
+	e.tableInfo = prev.tableInfo
+	e.nodeCache = prev.nodeCache
+
+	if reflect.DeepEqual(e.opts.CustomQueries, prev.opts.CustomQueries) &&
+		e.opts.Namespace == prev.opts.Namespace &&
+		reflect.DeepEqual(e.opts.ConstLabels, prev.opts.ConstLabels) {
+		e.customQueryCache = prev.customQueryCache
+	}
+}
+
+// sendReloadStatus sends the result of the last config reload if the exporter is served by the server
+func (e *RethinkdbExporter) sendReloadStatus(ch chan<- prometheus.Metric) {
+	if e.reloadStatus == nil {
+		return
+	}
+	success, successTime := e.reloadStatus.get()
+	ch <- prometheus.MustNewConstMetric(e.metrics.configLastReloadSuccessful, prometheus.GaugeValue, boolToFloat(success))
+	ch <- prometheus.MustNewConstMetric(e.metrics.configLastReloadSuccessTimestamp, prometheus.GaugeValue, float64(successTime.Unix()))
+}
@@ -0,0 +1,86 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+const bytesInMegabyte = 1024 * 1024
+
+func init() {
+	registerCollector("server_status", true, (*RethinkdbExporter).collectServerStatus)
+}
+
+// canonicalAddress is the address other servers use to connect, port is the cluster port
+type canonicalAddress struct {
+	Host string `rethinkdb:"host"`
+	Port int    `rethinkdb:"port"`
+}
+
+type serverStatus struct {
+	ID      string `rethinkdb:"id"`
+	Name    string `rethinkdb:"name"`
+	Network struct {
+		CanonicalAddresses []canonicalAddress `rethinkdb:"canonical_addresses"`
+		ClusterPort        int                `rethinkdb:"cluster_port"`
+		ConnectedTo        map[string]bool    `rethinkdb:"connected_to"`
+		Hostname           string             `rethinkdb:"hostname"`
+		HTTPAdminPort      interface{}        `rethinkdb:"http_admin_port"` // string if http admin is disabled
+		ReqlPort           int                `rethinkdb:"reql_port"`
+		TimeConnected      time.Time          `rethinkdb:"time_connected"`
+	} `rethinkdb:"network"`
+	Process struct {
+		CacheSizeMB float64   `rethinkdb:"cache_size_mb"`
+		TimeStarted time.Time `rethinkdb:"time_started"`
+		Version     string    `rethinkdb:"version"`
+	} `rethinkdb:"process"`
+}
+
+// collectServerStatus exports health of the servers from server_status system table.
+// Disconnected servers are absent in the table, they are found in connected_to of other servers.
+func (e *RethinkdbExporter) collectServerStatus(ctx context.Context, rconn r.QueryExecutor, ch chan<- prometheus.Metric) int {
+	var statuses []serverStatus
+	err := r.DB(r.SystemDatabase).Table(r.ServerStatusSystemTable).ReadAll(&statuses, rconn, r.RunOpts{Context: ctx})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to query server status table")
+		return 1
+	}
+
+	now := time.Now()
+	up := make(map[string]bool, len(statuses))
+	for _, status := range statuses {
+		if !e.serverAllowed(status.Name) {
+			continue
+		}
+		up[status.Name] = true
+
+		ch <- prometheus.MustNewConstMetric(e.metrics.serverUptimeSeconds, prometheus.GaugeValue, now.Sub(status.Process.TimeStarted).Seconds(), status.Name)
+		ch <- prometheus.MustNewConstMetric(e.metrics.serverConnectedSeconds, prometheus.GaugeValue, now.Sub(status.Network.TimeConnected).Seconds(), status.Name)
+		ch <- prometheus.MustNewConstMetric(e.metrics.serverCacheSizeBytes, prometheus.GaugeValue, status.Process.CacheSizeMB*bytesInMegabyte, status.Name)
+		ch <- prometheus.MustNewConstMetric(e.metrics.serverInfo, prometheus.GaugeValue, 1,
+			status.Name,
+			status.Network.Hostname,
+			status.Process.Version,
+			fmt.Sprint(status.Network.ReqlPort),
+			fmt.Sprint(status.Network.HTTPAdminPort),
+			fmt.Sprint(status.Network.ClusterPort),
+		)
+	}
+	for _, status := range statuses {
+		for peer := range status.Network.ConnectedTo {
+			if _, ok := up[peer]; !ok && e.serverAllowed(peer) {
+				up[peer] = false
+			}
+		}
+	}
+
+	for server, isUp := range up {
+		ch <- prometheus.MustNewConstMetric(e.metrics.serverUp, prometheus.GaugeValue, boolToFloat(isUp), server)
+	}
+	return 0
+}
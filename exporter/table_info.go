@@ -0,0 +1,130 @@
+package exporter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+// tableInfoExpiration is duration after that estimates of the table not seen in stats are removed from cache
+const tableInfoExpiration = 10 * time.Minute
+
+type info struct {
+	DocCountEstimates []float64 `rethinkdb:"doc_count_estimates"`
+}
+
+// tableInfoKey identifies the table, connection is a part of the key because probes query different clusters
+type tableInfoKey struct {
+	rconn r.QueryExecutor
+	db    string
+	table string
+}
+
+type tableInfoEntry struct {
+	rowsCount float64
+	updated   time.Time
+	lastSeen  time.Time
+}
+
+// tableInfoCache keeps table rows count estimates between refreshes
+type tableInfoCache struct {
+	m       sync.Mutex
+	entries map[tableInfoKey]*tableInfoEntry
+}
+
+func newTableInfoCache() *tableInfoCache {
+	return &tableInfoCache{
+		entries: make(map[tableInfoKey]*tableInfoEntry),
+	}
+}
+
+// get returns cached entry copy and marks the table as seen
+func (c *tableInfoCache) get(key tableInfoKey, now time.Time) (tableInfoEntry, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return tableInfoEntry{}, false
+	}
+	entry.lastSeen = now
+	return *entry, true
+}
+
+func (c *tableInfoCache) set(key tableInfoKey, rowsCount float64, now time.Time) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.entries[key] = &tableInfoEntry{
+		rowsCount: rowsCount,
+		updated:   now,
+		lastSeen:  now,
+	}
+}
+
+// expire removes entries of the tables not seen since the time
+func (c *tableInfoCache) expire(since time.Time) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for key, entry := range c.entries {
+		if entry.lastSeen.Before(since) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// processTableInfo sends rows count estimates of the table.
+// Estimates are served from cache until refresh interval passes, queries are limited by the workers semaphore.
+// Cached value is sent if the refresh fails or no worker is acquired before the context is done.
+func (e *RethinkdbExporter) processTableInfo(ctx context.Context, rconn r.QueryExecutor, dbName, tableName string, wg *errgroup.Group, ch chan<- prometheus.Metric) {
+	key := tableInfoKey{rconn: rconn, db: dbName, table: tableName}
+
+	now := time.Now()
+	cached, ok := e.tableInfo.get(key, now)
+	if ok && now.Sub(cached.updated) < e.opts.TableInfoRefreshInterval {
+		e.sendTableInfo(cached, dbName, tableName, ch)
+		return
+	}
+
+	wg.Go(func() error {
+		err := e.tableInfoWorkers.Acquire(ctx, 1)
+		if err != nil {
+			if ok {
+				e.sendTableInfo(cached, dbName, tableName, ch)
+			}
+			return err
+		}
+		defer e.tableInfoWorkers.Release(1)
+
+		var info info
+		err = r.DB(dbName).Table(tableName).Info().ReadOne(&info, rconn, r.RunOpts{Context: ctx})
+		if err != nil {
+			log.Warn().Err(err).Str("db", dbName).Str("table", tableName).Msg("failed to get table info")
+			if ok {
+				e.sendTableInfo(cached, dbName, tableName, ch)
+			}
+			return err
+		}
+
+		sum := 0.0
+		for _, e := range info.DocCountEstimates {
+			sum += float64(e)
+		}
+
+		updated := time.Now()
+		e.tableInfo.set(key, sum, updated)
+		e.sendTableInfo(tableInfoEntry{rowsCount: sum, updated: updated}, dbName, tableName, ch)
+		return nil
+	})
+}
+
+func (e *RethinkdbExporter) sendTableInfo(entry tableInfoEntry, dbName, tableName string, ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(e.metrics.tableRowsCount, prometheus.GaugeValue, entry.rowsCount, dbName, tableName)
+	ch <- prometheus.MustNewConstMetric(e.metrics.tableRowsCountAgeSeconds, prometheus.GaugeValue, time.Since(entry.updated).Seconds(), dbName, tableName)
+}
@@ -0,0 +1,45 @@
+package exporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+func TestProcessTableInfoAcquireFailureSendsCached(t *testing.T) {
+	e := &RethinkdbExporter{
+		opts:             Options{TableInfoRefreshInterval: time.Minute},
+		tableInfo:        newTableInfoCache(),
+		tableInfoWorkers: semaphore.NewWeighted(1),
+	}
+	e.metrics.tableRowsCount = prometheus.NewDesc("rows", "", []string{"db", "table"}, nil)
+	e.metrics.tableRowsCountAgeSeconds = prometheus.NewDesc("rows_age", "", []string{"db", "table"}, nil)
+
+	rconn := r.NewMock()
+	key := tableInfoKey{rconn: rconn, db: "db", table: "table"}
+	e.tableInfo.set(key, 42, time.Now().Add(-time.Hour))
+
+	// all workers are busy and the scrape is already timed out
+	if !e.tableInfoWorkers.TryAcquire(1) {
+		t.Fatal("failed to acquire worker")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ch := make(chan prometheus.Metric, 2)
+	var wg errgroup.Group
+	e.processTableInfo(ctx, rconn, "db", "table", &wg, ch)
+	if err := wg.Wait(); err == nil {
+		t.Error("expected acquire error")
+	}
+	close(ch)
+
+	if len(ch) != 2 {
+		t.Fatalf("got %d metrics, want cached rows count and age", len(ch))
+	}
+}
@@ -0,0 +1,148 @@
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is a web config file of the exporter in the format of prometheus exporters
+type Config struct {
+	// TLSServerConfig enables https with the certificate
+	TLSServerConfig *TLSServerConfig `yaml:"tls_server_config"`
+	// BasicAuthUsers maps user names to bcrypt hashes of their passwords
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+}
+
+// TLSServerConfig defines https parameters
+type TLSServerConfig struct {
+	// CertFile is a path to the server certificate
+	CertFile string `yaml:"cert_file"`
+	// KeyFile is a path to the key of the server certificate
+	KeyFile string `yaml:"key_file"`
+	// ClientAuthType is a policy of client certificates, e.g. RequireAndVerifyClientCert
+	ClientAuthType string `yaml:"client_auth_type"`
+	// ClientCAFile is a path to CA of the client certificates
+	ClientCAFile string `yaml:"client_ca_file"`
+	// MinVersion is the minimum TLS version: TLS10, TLS11, TLS12 or TLS13
+	MinVersion string `yaml:"min_version"`
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// versionTLS13 is tls.VersionTLS13 which is defined since Go 1.12
+const versionTLS13 = 0x0304
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": versionTLS13,
+}
+
+// LoadConfig reads and validates web config file.
+// Relative paths of the certificate files are resolved against the directory of the config file.
+func LoadConfig(path string) (*Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := new(Config)
+	err = yaml.UnmarshalStrict(content, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.TLSServerConfig != nil {
+		dir := filepath.Dir(path)
+		cfg.TLSServerConfig.CertFile = joinDir(dir, cfg.TLSServerConfig.CertFile)
+		cfg.TLSServerConfig.KeyFile = joinDir(dir, cfg.TLSServerConfig.KeyFile)
+		cfg.TLSServerConfig.ClientCAFile = joinDir(dir, cfg.TLSServerConfig.ClientCAFile)
+	}
+
+	for user, hash := range cfg.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("basic auth user '%v': password is not a bcrypt hash: %v", user, err)
+		}
+	}
+	return cfg, nil
+}
+
+func joinDir(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// files returns paths of the files referenced by the config
+func (c *Config) files() []string {
+	if c.TLSServerConfig == nil {
+		return nil
+	}
+	return []string{c.TLSServerConfig.CertFile, c.TLSServerConfig.KeyFile, c.TLSServerConfig.ClientCAFile}
+}
+
+// buildTLSConfig loads certificates of the https server
+func (c *TLSServerConfig) buildTLSConfig() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("cert file and key file must be both specified")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("TLS file load error: %v", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.MinVersion != "" {
+		version, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version '%v'", c.MinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if c.ClientCAFile != "" {
+		ca, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("TLS client CA file load error: %v", err)
+		}
+		cp := x509.NewCertPool()
+		if !cp.AppendCertsFromPEM(ca) {
+			return nil, errors.New("TLS credentials: failed to append client ca")
+		}
+		config.ClientCAs = cp
+		// client CA without auth type means mTLS
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	if c.ClientAuthType != "" {
+		authType, ok := clientAuthTypes[c.ClientAuthType]
+		if !ok {
+			return nil, fmt.Errorf("unknown client auth type '%v'", c.ClientAuthType)
+		}
+		if authType >= tls.VerifyClientCertIfGiven && config.ClientCAs == nil {
+			return nil, fmt.Errorf("client auth type '%v' requires client CA file", c.ClientAuthType)
+		}
+		config.ClientAuth = authType
+	}
+
+	return config, nil
+}
@@ -0,0 +1,110 @@
+package web
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeConfig writes the web config file to a temporary directory and returns its path and the directory removal
+func writeConfig(t *testing.T, content string) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "web-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remove := func() { os.RemoveAll(dir) }
+
+	path := filepath.Join(dir, "web.yml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		remove()
+		t.Fatal(err)
+	}
+	return path, remove
+}
+
+func testHash(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func TestLoadConfig(t *testing.T) {
+	hash := testHash(t, "secret")
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "empty", content: ""},
+		{name: "basic auth", content: "basic_auth_users:\n  admin: " + hash + "\n"},
+		{name: "tls", content: "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n"},
+		{name: "unknown field", content: "basic_auth:\n  admin: " + hash + "\n", wantErr: "field basic_auth not found"},
+		{name: "invalid yaml", content: "basic_auth_users: [", wantErr: "yaml"},
+		{name: "plain password", content: "basic_auth_users:\n  admin: secret\n", wantErr: "basic auth user 'admin': password is not a bcrypt hash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, remove := writeConfig(t, tt.content)
+			defer remove()
+
+			_, err := LoadConfig(path)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("LoadConfig() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("LoadConfig() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigRelativePaths(t *testing.T) {
+	path, remove := writeConfig(t, "tls_server_config:\n  cert_file: server.crt\n  key_file: /etc/exporter/server.key\n")
+	defer remove()
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Dir(path)
+	if want := filepath.Join(dir, "server.crt"); cfg.TLSServerConfig.CertFile != want {
+		t.Errorf("CertFile = %v, want %v", cfg.TLSServerConfig.CertFile, want)
+	}
+	if want := "/etc/exporter/server.key"; cfg.TLSServerConfig.KeyFile != want {
+		t.Errorf("KeyFile = %v, want %v", cfg.TLSServerConfig.KeyFile, want)
+	}
+	if cfg.TLSServerConfig.ClientCAFile != "" {
+		t.Errorf("ClientCAFile = %v, want empty", cfg.TLSServerConfig.ClientCAFile)
+	}
+}
+
+func TestBuildTLSConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     TLSServerConfig
+		wantErr string
+	}{
+		{name: "no key", cfg: TLSServerConfig{CertFile: "server.crt"}, wantErr: "cert file and key file must be both specified"},
+		{name: "missing files", cfg: TLSServerConfig{CertFile: "missing.crt", KeyFile: "missing.key"}, wantErr: "TLS file load error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.cfg.buildTLSConfig()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("buildTLSConfig() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
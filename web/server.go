@@ -0,0 +1,199 @@
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash is compared with passwords of unknown users to not reveal valid user names by response time
+const dummyHash = "$2a$10$Ys7CNaGJYQ9dFlxVi9oOm.Ob78e5H4hJmx9hcpUpnHV59cjvjK9xW"
+
+// ConfigWatcher keeps web config and reloads it when the config file or certificate files are modified.
+// Enabling or disabling TLS requires restart, other changes are applied to new connections and requests.
+type ConfigWatcher struct {
+	path string
+
+	m         sync.Mutex
+	cfg       *Config
+	tlsConfig *tls.Config
+	stamps    map[string]fileStamp
+	// authCache keeps successfully checked credentials, bcrypt is too slow to run on every scrape
+	authCache map[string]bool
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewConfigWatcher loads web config file, it fails if the config or certificates are invalid
+func NewConfigWatcher(path string) (*ConfigWatcher, error) {
+	w := &ConfigWatcher{path: path}
+	err := w.reload()
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// TLSEnabled tells if the server must serve https
+func (w *ConfigWatcher) TLSEnabled() bool {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	return w.tlsConfig != nil
+}
+
+// reload loads config and certificates and replaces the current ones if they are valid
+func (w *ConfigWatcher) reload() error {
+	stamps := map[string]fileStamp{w.path: statFile(w.path)}
+
+	cfg, err := LoadConfig(w.path)
+	if err != nil {
+		return err
+	}
+	var tlsConfig *tls.Config
+	if cfg.TLSServerConfig != nil {
+		tlsConfig, err = cfg.TLSServerConfig.buildTLSConfig()
+		if err != nil {
+			return err
+		}
+	}
+	for _, path := range cfg.files() {
+		if path != "" {
+			stamps[path] = statFile(path)
+		}
+	}
+
+	if w.cfg != nil && (w.tlsConfig == nil) != (tlsConfig == nil) {
+		return errors.New("enabling or disabling TLS requires restart")
+	}
+
+	w.cfg = cfg
+	w.tlsConfig = tlsConfig
+	w.stamps = stamps
+	w.authCache = make(map[string]bool)
+	return nil
+}
+
+// current returns the config reloaded if its files are modified.
+// Invalid config is logged and the previous one is kept.
+func (w *ConfigWatcher) current() (*Config, *tls.Config) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	for path, stamp := range w.stamps {
+		if statFile(path) == stamp {
+			continue
+		}
+		err := w.reload()
+		if err != nil {
+			log.Error().Err(err).Str("path", w.path).Msg("failed to reload web config, keeping the previous one")
+			// to not retry until the files are modified again
+			for path := range w.stamps {
+				w.stamps[path] = statFile(path)
+			}
+		} else {
+			log.Info().Str("path", w.path).Msg("web config reloaded")
+		}
+		break
+	}
+	return w.cfg, w.tlsConfig
+}
+
+func statFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
+
+// getConfigForClient returns TLS config of the current web config for every handshake
+func (w *ConfigWatcher) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	_, tlsConfig := w.current()
+	return tlsConfig, nil
+}
+
+// BasicAuth checks basic auth credentials of the requests if users are configured.
+// Public paths are served without credentials, e.g. health checks.
+func (w *ConfigWatcher) BasicAuth(next http.Handler, public ...string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		for _, path := range public {
+			if req.URL.Path == path {
+				next.ServeHTTP(rw, req)
+				return
+			}
+		}
+
+		cfg, _ := w.current()
+		if len(cfg.BasicAuthUsers) == 0 {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		user, password, ok := req.BasicAuth()
+		if !ok || !w.checkPassword(cfg, user, password) {
+			rw.Header().Set("WWW-Authenticate", `Basic realm="RethinkDB Exporter"`)
+			http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+func (w *ConfigWatcher) checkPassword(cfg *Config, user, password string) bool {
+	hash, known := cfg.BasicAuthUsers[user]
+	if !known {
+		hash = dummyHash
+	}
+
+	sum := sha256.Sum256([]byte(password))
+	cacheKey := user + ":" + hash + ":" + hex.EncodeToString(sum[:])
+
+	w.m.Lock()
+	cached := w.authCache[cacheKey]
+	w.m.Unlock()
+	if cached {
+		return true
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil || !known {
+		return false
+	}
+
+	w.m.Lock()
+	w.authCache[cacheKey] = true
+	w.m.Unlock()
+	return true
+}
+
+// ListenAndServe serves http or https depending on the web config file, plain http if the path is empty.
+// Basic auth is not required for public paths.
+func ListenAndServe(server *http.Server, configFile string, public ...string) error {
+	if configFile == "" {
+		return server.ListenAndServe()
+	}
+
+	w, err := NewConfigWatcher(configFile)
+	if err != nil {
+		return err
+	}
+	server.Handler = w.BasicAuth(server.Handler, public...)
+
+	if !w.TLSEnabled() {
+		return server.ListenAndServe()
+	}
+	server.TLSConfig = &tls.Config{GetConfigForClient: w.getConfigForClient}
+	return server.ListenAndServeTLS("", "")
+}
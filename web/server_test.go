@@ -0,0 +1,67 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBasicAuth(t *testing.T) {
+	path, remove := writeConfig(t, "basic_auth_users:\n  admin: "+testHash(t, "secret")+"\n")
+	defer remove()
+
+	w, err := NewConfigWatcher(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := w.BasicAuth(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), "/healthz")
+
+	tests := []struct {
+		name     string
+		path     string
+		user     string
+		password string
+		noAuth   bool
+		want     int
+	}{
+		{name: "valid", path: "/metrics", user: "admin", password: "secret", want: http.StatusOK},
+		{name: "valid cached", path: "/metrics", user: "admin", password: "secret", want: http.StatusOK},
+		{name: "wrong password", path: "/metrics", user: "admin", password: "wrong", want: http.StatusUnauthorized},
+		{name: "unknown user", path: "/metrics", user: "guest", password: "secret", want: http.StatusUnauthorized},
+		{name: "no credentials", path: "/metrics", noAuth: true, want: http.StatusUnauthorized},
+		{name: "public path", path: "/healthz", noAuth: true, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if !tt.noAuth {
+				req.SetBasicAuth(tt.user, tt.password)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %v, want %v", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("WWW-Authenticate header is not set")
+			}
+		})
+	}
+}
+
+func TestBasicAuthDisabled(t *testing.T) {
+	path, remove := writeConfig(t, "")
+	defer remove()
+
+	w, err := NewConfigWatcher(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := w.BasicAuth(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v", rec.Code, http.StatusOK)
+	}
+}